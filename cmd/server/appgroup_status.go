@@ -2,82 +2,36 @@ package main
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/openinfradev/tks-api/pkg/domain"
-	"github.com/openinfradev/tks-api/pkg/log"
+	"github.com/openinfradev/tks-batch/internal/reconciler"
 )
 
-func processAppGroupStatus() error {
-
-	// get appgroups
-	appGroups, err := applicationAccessor.GetIncompleteAppGroups()
-	if err != nil {
-		return err
-	}
-	if len(appGroups) == 0 {
-		return nil
-	}
-	log.Info(context.TODO(), "[processAppGroupStatus] appGroups : ", appGroups)
-
-	for i := range appGroups {
-		appGroup := appGroups[i]
-
-		appGroupId := appGroup.ID
-		workflowId := appGroup.WorkflowId
-		status := appGroup.Status
-		statusDesc := appGroup.StatusDesc
-
-		// update appgroup status
-		var newStatus domain.AppGroupStatus
-		var newMessage string
-
-		if workflowId != "" {
-			workflow, err := argowfClient.GetWorkflow(context.TODO(), "argo", workflowId)
-			if err != nil {
-				log.Error(context.TODO(), "failed to get argo workflow. err : ", err)
-				continue
-			}
-			newMessage = fmt.Sprintf("(%s) %s", workflow.Status.Progress, workflow.Status.Message)
-			log.Debug(context.TODO(), fmt.Sprintf("status [%s], newMessage [%s], phase [%s]", status, newMessage, workflow.Status.Phase))
-			if status == domain.AppGroupStatus_INSTALLING {
-				switch workflow.Status.Phase {
-				case "Running":
-					newStatus = domain.AppGroupStatus_INSTALLING
-				case "Succeeded":
-					newStatus = domain.AppGroupStatus_RUNNING
-				case "Failed":
-					newStatus = domain.AppGroupStatus_INSTALL_ERROR
-				case "Error":
-					newStatus = domain.AppGroupStatus_INSTALL_ERROR
-				}
-			} else if status == domain.AppGroupStatus_DELETING {
-				switch workflow.Status.Phase {
-				case "Running":
-					newStatus = domain.AppGroupStatus_DELETING
-				case "Succeeded":
-					newStatus = domain.AppGroupStatus_DELETED
-				case "Failed":
-					newStatus = domain.AppGroupStatus_DELETE_ERROR
-				case "Error":
-					newStatus = domain.AppGroupStatus_DELETE_ERROR
-				}
-			}
-			if newStatus == domain.AppGroupStatus_PENDING {
-				continue
-			}
-		} else {
-			continue
+var appGroupKind = reconciler.NewKind(
+	reconciler.KindAppGroup,
+	reconciler.AppGroupTransitions,
+	func() ([]reconciler.Entity[domain.AppGroupStatus], error) {
+		appGroups, err := applicationAccessor.GetIncompleteAppGroups()
+		if err != nil {
+			return nil, err
 		}
-
-		if status != newStatus || statusDesc != newMessage {
-			log.Debug(context.TODO(), fmt.Sprintf("update status!! appGroupId [%s], newStatus [%s], newMessage [%s]", appGroupId, newStatus, newMessage))
-			err := applicationAccessor.UpdateAppGroupStatus(appGroupId, newStatus, newMessage, workflowId)
-			if err != nil {
-				log.Error(context.TODO(), "Failed to update appgroup status err : ", err)
-				continue
+		entities := make([]reconciler.Entity[domain.AppGroupStatus], len(appGroups))
+		for i, appGroup := range appGroups {
+			entities[i] = reconciler.Entity[domain.AppGroupStatus]{
+				ID:             appGroup.ID,
+				OrganizationId: appGroup.OrganizationId,
+				WorkflowId:     appGroup.WorkflowId,
+				Status:         appGroup.Status,
+				StatusDesc:     appGroup.StatusDesc,
 			}
 		}
-	}
-	return nil
+		return entities, nil
+	},
+	func(id string, status domain.AppGroupStatus, statusDesc string, workflowId string) error {
+		return applicationAccessor.UpdateAppGroupStatus(id, status, statusDesc, workflowId)
+	},
+)
+
+func processAppGroupStatus(ctx context.Context) error {
+	return statusReconciler.Reconcile(ctx, reconciler.KindAppGroup)
 }
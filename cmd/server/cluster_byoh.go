@@ -5,15 +5,25 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/openinfradev/tks-api/pkg/domain"
 	"github.com/openinfradev/tks-api/pkg/log"
+	"github.com/openinfradev/tks-batch/internal/reconciler"
 	"github.com/spf13/viper"
 )
 
-var token string
+// TOKEN_CHECK_TTL is how long a verified token of tks-api is used without verifying it again.
+const TOKEN_CHECK_TTL = time.Minute
 
-func processClusterByoh() error {
+var (
+	token          string
+	tokenCheckedAt time.Time
+	tokenMutex     sync.Mutex
+)
+
+func processClusterByoh(ctx context.Context) error {
 	// get clusters
 	clusters, err := clusterAccessor.GetBootstrappedByohClusters()
 	if err != nil {
@@ -22,12 +32,9 @@ func processClusterByoh() error {
 	if len(clusters) == 0 {
 		return nil
 	}
-	log.Info(context.TODO(), "[processClusterByoh] byoh clusters : ", clusters)
+	log.Info(ctx, "[processClusterByoh] byoh clusters : ", clusters)
 
-	token = getTksApiToken()
-	if token != "" {
-		apiClient.SetToken(token)
-	}
+	getTksApiToken()
 	for _, cluster := range clusters {
 		clusterId := cluster.ID
 
@@ -35,7 +42,7 @@ func processClusterByoh() error {
 		url := fmt.Sprintf("clusters/%s/nodes", clusterId)
 		body, err := apiClient.Get(url)
 		if err != nil {
-			log.Error(context.TODO(), err)
+			log.Error(ctx, err)
 			continue
 		}
 
@@ -48,25 +55,32 @@ func processClusterByoh() error {
 				completed = false
 			}
 		}
-		log.Info(context.TODO(), out.Nodes)
+		log.Info(ctx, out.Nodes)
 
 		//completed = true // FOR TEST
 		if completed {
-			log.Info(context.TODO(), fmt.Sprintf("all agents registered! starting stack creation. clusterId %s", clusterId))
+			log.Info(ctx, fmt.Sprintf("all agents registered! starting stack creation. clusterId %s", clusterId))
 			// clusterId, newStatus, newMessage, workflowId
 			if err = clusterAccessor.UpdateClusterStatus(clusterId, domain.ClusterStatus_INSTALLING, "", ""); err != nil {
-				log.Error(context.TODO(), "Failed to update cluster status err : ", err)
+				log.Error(ctx, "Failed to update cluster status err : ", err)
 				continue
 			}
+			statusReconciler.Notify(ctx, reconciler.Event{
+				Kind:           reconciler.KindCluster,
+				EntityId:       clusterId,
+				OrganizationId: cluster.OrganizationId,
+				OldStatus:      cluster.Status.String(),
+				NewStatus:      domain.ClusterStatus_INSTALLING.String(),
+			})
 
 			if cluster.IsStack {
 				if _, err = apiClient.Post(fmt.Sprintf("organizations/%s/stacks/%s/install", cluster.OrganizationId, clusterId), nil); err != nil {
-					log.Error(context.TODO(), err)
+					log.Error(ctx, err)
 					continue
 				}
 			} else {
 				if _, err = apiClient.Post("clusters/"+clusterId+"/install", nil); err != nil {
-					log.Error(context.TODO(), err)
+					log.Error(ctx, err)
 					continue
 				}
 			}
@@ -88,7 +102,16 @@ func transcode(in, out interface{}) {
 	}
 }
 
+// getTksApiToken returns the token of tks-api and sets it to apiClient.
+// The token is verified at most once per TOKEN_CHECK_TTL and a new one is issued when it is not valid.
 func getTksApiToken() string {
+	tokenMutex.Lock()
+	defer tokenMutex.Unlock()
+
+	if token != "" && time.Since(tokenCheckedAt) < TOKEN_CHECK_TTL {
+		return token
+	}
+
 	_, err := apiClient.Get("auth/verify-token")
 	if err != nil {
 		body, err := apiClient.Post("auth/login", domain.LoginRequest{
@@ -103,9 +126,10 @@ func getTksApiToken() string {
 		var out domain.LoginResponse
 		transcode(body, &out)
 
-		log.Info(context.TODO(), out.User.Token)
 		token = out.User.Token
+		apiClient.SetToken(token)
 	}
+	tokenCheckedAt = time.Now()
 
 	return token
 }
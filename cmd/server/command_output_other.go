@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// separateCommandOutput returns the standard output. The logs are not separated from the output of a command.
+func separateCommandOutput() (*os.File, error) {
+	return os.Stdout, nil
+}
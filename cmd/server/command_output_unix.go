@@ -0,0 +1,25 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// separateCommandOutput returns a file for the output of a command and sends anything else written
+// to the standard output, such as the logs, to the standard error.
+// The logger of tks-api writes to the standard output and cannot be configured otherwise.
+func separateCommandOutput() (*os.File, error) {
+	stdout := int(os.Stdout.Fd())
+	fd, err := unix.Dup(stdout)
+	if err != nil {
+		return nil, err
+	}
+	if err = unix.Dup2(int(os.Stderr.Fd()), stdout); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), "/dev/stdout"), nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openinfradev/tks-batch/internal/reconciler"
+	"github.com/prometheus/common/model"
+)
+
+const DEFAULT_STUCK_TIMEOUTS = "cluster.BOOTSTRAPPING=2h,cluster.INSTALLING=3h,cluster.DELETING=3h," +
+	"appgroup.INSTALLING=2h,appgroup.DELETING=2h," +
+	"organization.CREATING=1h,organization.DELETING=1h," +
+	"cloudaccount.CREATING=1h,cloudaccount.DELETING=1h"
+
+// parseKeyValues parses a flag value such as "a=1,b=2".
+func parseKeyValues(s string) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid key=value pair [%s]", item)
+		}
+		out[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return out, nil
+}
+
+// parseStuckTimeouts parses "<kind>.<status>=<duration>,..." into timeouts by kind and status.
+func parseStuckTimeouts(s string) (map[string]map[string]time.Duration, error) {
+	kvs, err := parseKeyValues(s)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]map[string]time.Duration)
+	for key, value := range kvs {
+		kindStatus := strings.SplitN(key, ".", 2)
+		if len(kindStatus) != 2 {
+			return nil, fmt.Errorf("invalid stuck timeout key [%s]. use <kind>.<status>", key)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stuck timeout of %s. err : %s", key, err)
+		}
+		if _, ok := out[kindStatus[0]]; !ok {
+			out[kindStatus[0]] = make(map[string]time.Duration)
+		}
+		out[kindStatus[0]][kindStatus[1]] = d
+	}
+	return out, nil
+}
+
+// parseRetryPolicies parses "<kind>=<max attempts>,..." into retry policies by kind.
+func parseRetryPolicies(s string, backoff time.Duration) (map[string]reconciler.RetryPolicy, error) {
+	kvs, err := parseKeyValues(s)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]reconciler.RetryPolicy)
+	for kind, value := range kvs {
+		attempts, err := strconv.Atoi(value)
+		if err != nil || attempts < 0 {
+			return nil, fmt.Errorf("invalid retry attempts of %s [%s]", kind, value)
+		}
+		out[kind] = reconciler.RetryPolicy{MaxAttempts: attempts, Backoff: backoff}
+	}
+	return out, nil
+}
+
+// ruleGroupConfig is the evaluation interval and limit of the rule groups with overrides by template.
+type ruleGroupConfig struct {
+	defaultInterval string
+	defaultLimit    int
+	intervals       map[string]string
+	limits          map[string]int
+}
+
+var ruleGroups ruleGroupConfig
+
+// interval returns the override of the template by its id, or else by its name, or else the default.
+func (c ruleGroupConfig) interval(templateId string, templateName string) string {
+	if interval, ok := c.intervals[templateId]; ok {
+		return interval
+	}
+	if interval, ok := c.intervals[templateName]; ok {
+		return interval
+	}
+	return c.defaultInterval
+}
+
+// limit returns the override of the template by its id, or else by its name, or else the default.
+func (c ruleGroupConfig) limit(templateId string, templateName string) int {
+	if limit, ok := c.limits[templateId]; ok {
+		return limit
+	}
+	if limit, ok := c.limits[templateName]; ok {
+		return limit
+	}
+	return c.defaultLimit
+}
+
+// parseRuleGroupConfig parses the default interval and limit and
+// the overrides in "<template>=<duration>,..." and "<template>=<limit>,..." where a template is its id or name.
+func parseRuleGroupConfig(interval string, limit int, intervals string, limits string) (ruleGroupConfig, error) {
+	c := ruleGroupConfig{
+		defaultInterval: interval,
+		defaultLimit:    limit,
+		intervals:       make(map[string]string),
+		limits:          make(map[string]int),
+	}
+	if interval != "" {
+		if _, err := model.ParseDuration(interval); err != nil {
+			return c, fmt.Errorf("invalid rule group interval [%s]. err : %s", interval, err)
+		}
+	}
+
+	kvs, err := parseKeyValues(intervals)
+	if err != nil {
+		return c, err
+	}
+	for template, value := range kvs {
+		if _, err := model.ParseDuration(value); err != nil {
+			return c, fmt.Errorf("invalid rule group interval of %s. err : %s", template, err)
+		}
+		c.intervals[template] = value
+	}
+
+	kvs, err = parseKeyValues(limits)
+	if err != nil {
+		return c, err
+	}
+	for template, value := range kvs {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return c, fmt.Errorf("invalid rule group limit of %s [%s]", template, value)
+		}
+		c.limits[template] = n
+	}
+	return c, nil
+}
+
+// reloadConfig is how thanos-ruler reloads the rules with overrides by organization.
+type reloadConfig struct {
+	defaultStrategy string
+	strategies      map[string]string
+}
+
+var reloads reloadConfig
+
+func (c reloadConfig) strategy(organizationId string) string {
+	if strategy, ok := c.strategies[organizationId]; ok {
+		return strategy
+	}
+	return c.defaultStrategy
+}
+
+// parseReloadConfig parses the default strategy and the overrides in "<organization>=<strategy>,...".
+func parseReloadConfig(strategy string, strategies string) (reloadConfig, error) {
+	c := reloadConfig{
+		defaultStrategy: strategy,
+		strategies:      make(map[string]string),
+	}
+	if !isReloadStrategy(strategy) {
+		return c, fmt.Errorf("invalid reload strategy [%s]", strategy)
+	}
+
+	kvs, err := parseKeyValues(strategies)
+	if err != nil {
+		return c, err
+	}
+	for organizationId, value := range kvs {
+		if !isReloadStrategy(value) {
+			return c, fmt.Errorf("invalid reload strategy of %s [%s]", organizationId, value)
+		}
+		c.strategies[organizationId] = value
+	}
+	return c, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+const DIFF_CONTEXT_LINES = 3
+
+// unifiedDiff returns the line diff from a to b in unified format, or an empty string if they are equal.
+func unifiedDiff(a string, b string, fromName string, toName string) string {
+	if a == b {
+		return ""
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(a),
+		B:        splitLines(b),
+		FromFile: fromName,
+		ToFile:   toName,
+		Context:  DIFF_CONTEXT_LINES,
+	})
+	if err != nil {
+		// it only fails to write to its buffer
+		return ""
+	}
+	return diff
+}
+
+// splitLines returns the lines of s, each with a line break.
+// Unlike difflib.SplitLines, it does not add an empty line after the last line break.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if last := len(lines) - 1; lines[last] == "" {
+		lines = lines[:last]
+	} else {
+		lines[last] += "\n"
+	}
+	return lines
+}
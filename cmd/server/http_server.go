@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/openinfradev/tks-api/pkg/log"
+	"github.com/openinfradev/tks-batch/internal/scheduler"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+const READINESS_TIMEOUT_SEC = 5
+
+type checkResult struct {
+	Name  string `json:"name"`
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type processorStatus struct {
+	Name            string    `json:"name"`
+	Running         bool      `json:"running"`
+	IntervalSec     float64   `json:"intervalSec"`
+	LastRun         time.Time `json:"lastRun"`
+	LastDurationSec float64   `json:"lastDurationSec"`
+	LastError       string    `json:"lastError"`
+	LastSuccess     time.Time `json:"lastSuccess"`
+}
+
+func newHttpServer(db *gorm.DB) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second*READINESS_TIMEOUT_SEC)
+		defer cancel()
+
+		results := checkReadiness(ctx, db)
+		status := http.StatusOK
+		for _, result := range results {
+			if !result.Ok {
+				status = http.StatusServiceUnavailable
+			}
+		}
+		writeJson(w, status, results)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, http.StatusOK, map[string]interface{}{
+			"leading":    jobScheduler.Started(),
+			"processors": processorStatuses(),
+		})
+	})
+
+	mux.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
+		kind := r.URL.Query().Get("kind")
+		id := r.URL.Query().Get("id")
+		if kind == "" || id == "" {
+			writeJson(w, http.StatusBadRequest, map[string]string{"error": "kind and id are required"})
+			return
+		}
+		histories, err := historyAccessor.GetTimeline(kind, id)
+		if err != nil {
+			writeJson(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJson(w, http.StatusOK, histories)
+	})
+	mux.HandleFunc("/rules", func(w http.ResponseWriter, r *http.Request) {
+		organizationId := r.URL.Query().Get("organization")
+		if organizationId == "" {
+			writeJson(w, http.StatusBadRequest, map[string]string{"error": "organization is required"})
+			return
+		}
+		statuses, err := ruleStatusAccessor.GetByOrganization(organizationId)
+		if err != nil {
+			writeJson(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJson(w, http.StatusOK, statuses)
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+
+	return &http.Server{
+		Addr:    fmt.Sprintf(":%d", viper.GetInt("port")),
+		Handler: mux,
+	}
+}
+
+func checkReadiness(ctx context.Context, db *gorm.DB) []checkResult {
+	results := []checkResult{}
+
+	sqlDB, err := db.DB()
+	if err == nil {
+		err = sqlDB.PingContext(ctx)
+	}
+	results = append(results, newCheckResult("database", err))
+
+	_, err = argowfClient.GetWorkflowTemplates(ctx, "argo")
+	results = append(results, newCheckResult("argo", err))
+
+	err = nil
+	if getTksApiToken() == "" {
+		err = fmt.Errorf("failed to login to tks-api")
+	}
+	results = append(results, newCheckResult("tks-api", err))
+
+	// processors only run on the leader. disabled processors are not scheduled at all.
+	if jobScheduler.Started() {
+		err = nil
+		for _, status := range jobScheduler.Status() {
+			if err = checkProcessor(status, time.Now()); err != nil {
+				break
+			}
+		}
+		results = append(results, newCheckResult("processors", err))
+	}
+
+	return results
+}
+
+// checkProcessor fails if the processor has not completed a run for longer than maxProcessorStale,
+// including when its first run has not completed since it was started.
+func checkProcessor(status scheduler.JobStatus, now time.Time) error {
+	maxStale := maxProcessorStale(status)
+	if status.LastRun.IsZero() {
+		if !status.StartedAt.IsZero() && now.Sub(status.StartedAt) > maxStale {
+			return fmt.Errorf("%s has not completed since it was started at %s", status.Name, status.StartedAt.Format(time.RFC3339))
+		}
+		return nil
+	}
+	finished := status.LastRun.Add(status.LastDuration)
+	if now.Sub(finished) > maxStale && now.Sub(status.LastRun) > maxStale {
+		return fmt.Errorf("%s has not completed since %s", status.Name, finished.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// maxProcessorStale returns how long a processor may go without completing before it is considered stuck.
+// A processor is given at least two intervals and its timeout, so that a long interval does not fail the readiness.
+func maxProcessorStale(status scheduler.JobStatus) time.Duration {
+	maxStale := time.Second * time.Duration(viper.GetInt("readiness-max-stale-sec"))
+	return max(maxStale, 2*status.Interval+status.Timeout)
+}
+
+func newCheckResult(name string, err error) checkResult {
+	if err != nil {
+		return checkResult{Name: name, Ok: false, Error: err.Error()}
+	}
+	return checkResult{Name: name, Ok: true}
+}
+
+func processorStatuses() []processorStatus {
+	statuses := jobScheduler.Status()
+	out := make([]processorStatus, len(statuses))
+	for i, status := range statuses {
+		out[i] = processorStatus{
+			Name:            status.Name,
+			Running:         status.Running,
+			IntervalSec:     status.Interval.Seconds(),
+			LastRun:         status.LastRun,
+			LastDurationSec: status.LastDuration.Seconds(),
+			LastError:       status.LastError,
+			LastSuccess:     status.LastSuccess,
+		}
+	}
+	return out
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error(context.TODO(), err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openinfradev/tks-batch/internal/scheduler"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxProcessorStale(t *testing.T) {
+	viper.Set("readiness-max-stale-sec", 300)
+	defer viper.Set("readiness-max-stale-sec", nil)
+
+	testCases := []struct {
+		name     string
+		interval time.Duration
+		timeout  time.Duration
+		want     time.Duration
+	}{
+		{"short interval", 5 * time.Second, time.Minute, 300 * time.Second},
+		{"long interval", time.Hour, 5 * time.Minute, 2*time.Hour + 5*time.Minute},
+		{"long timeout", 5 * time.Second, 10 * time.Minute, 10*time.Minute + 10*time.Second},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := maxProcessorStale(scheduler.JobStatus{Interval: tc.interval, Timeout: tc.timeout})
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestCheckProcessor(t *testing.T) {
+	viper.Set("readiness-max-stale-sec", 300)
+	defer viper.Set("readiness-max-stale-sec", nil)
+
+	now := time.Now()
+	testCases := []struct {
+		name    string
+		status  scheduler.JobStatus
+		wantErr bool
+	}{
+		{"not started", scheduler.JobStatus{}, false},
+		{"first run in progress", scheduler.JobStatus{StartedAt: now.Add(-time.Minute)}, false},
+		{"first run never completed", scheduler.JobStatus{StartedAt: now.Add(-10 * time.Minute)}, true},
+		{"completed recently", scheduler.JobStatus{StartedAt: now.Add(-time.Hour), LastRun: now.Add(-time.Minute)}, false},
+		{"long run completed recently", scheduler.JobStatus{StartedAt: now.Add(-time.Hour), LastRun: now.Add(-10 * time.Minute), LastDuration: 9 * time.Minute}, false},
+		{"not completed since", scheduler.JobStatus{StartedAt: now.Add(-time.Hour), LastRun: now.Add(-10 * time.Minute), LastDuration: time.Second}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.status.Name = "test"
+			err := checkProcessor(tc.status, now)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
@@ -4,6 +4,11 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	_apiClient "github.com/openinfradev/tks-api/pkg/api-client"
@@ -13,8 +18,19 @@ import (
 	cloudAccount "github.com/openinfradev/tks-batch/internal/cloud-account"
 	"github.com/openinfradev/tks-batch/internal/cluster"
 	"github.com/openinfradev/tks-batch/internal/database"
+	"github.com/openinfradev/tks-batch/internal/event"
+	"github.com/openinfradev/tks-batch/internal/history"
+	"github.com/openinfradev/tks-batch/internal/leader"
+	"github.com/openinfradev/tks-batch/internal/metrics"
 	"github.com/openinfradev/tks-batch/internal/organization"
+	"github.com/openinfradev/tks-batch/internal/reconciler"
+	ruleStatus "github.com/openinfradev/tks-batch/internal/rule-status"
+	rulerReload "github.com/openinfradev/tks-batch/internal/ruler-reload"
+	"github.com/openinfradev/tks-batch/internal/scheduler"
+	statusEntry "github.com/openinfradev/tks-batch/internal/status-entry"
 	systemNotificationRule "github.com/openinfradev/tks-batch/internal/system-notification-rule"
+	"github.com/openinfradev/tks-batch/internal/workflow"
+	workflowRetry "github.com/openinfradev/tks-batch/internal/workflow-retry"
 	gcache "github.com/patrickmn/go-cache"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -22,6 +38,9 @@ import (
 
 const INTERVAL_SEC = 5
 
+// command is a subcommand given with the flags, such as "rules render".
+var command []string
+
 var (
 	argowfClient                   argo.ArgoClient
 	clusterAccessor                *cluster.ClusterAccessor
@@ -29,14 +48,36 @@ var (
 	cloudAccountAccessor           *cloudAccount.CloudAccountAccessor
 	organizationAccessor           *organization.OrganizationAccessor
 	systemNotificationRuleAccessor *systemNotificationRule.SystemNotificationAccessor
+	historyAccessor                *history.HistoryAccessor
+	ruleStatusAccessor             *ruleStatus.RuleStatusAccessor
+	rulerReloadAccessor            *rulerReload.RulerReloadAccessor
+	workflowRetryAccessor          *workflowRetry.WorkflowRetryAccessor
+	statusEntryAccessor            *statusEntry.StatusEntryAccessor
 	apiClient                      _apiClient.ApiClient
+	statusReconciler               *reconciler.Reconciler
+	eventPublisher                 *event.Publisher
+	workflowWatcher                *workflow.Watcher
+	jobScheduler                   *scheduler.Scheduler
 	cache                          *gcache.Cache
 )
 
 func init() {
 	flag.Int("port", 9112, "service port")
+	flag.Int("readiness-max-stale-sec", 300, "not ready when a processor has not completed for this duration or two intervals and the timeout of the processor, whichever is longer")
 	flag.String("argo-address", "localhost", "server address for argo-workflow-server")
 	flag.Int("argo-port", 2746, "server port for argo-workflow-server")
+	flag.String("argo-workflow-source", workflow.ModeWatch, "how to read argo workflows. one of watch, list and poll")
+	flag.Int("argo-resync-sec", 60, "interval to reconcile all statuses even without workflow changes in watch mode")
+	flag.Int("status-history-retention-days", 180, "days to keep status histories. 0 keeps them forever")
+	flag.String("stuck-timeouts", DEFAULT_STUCK_TIMEOUTS, "how long an entity may stay in a status before it is moved to the error status. <kind>.<status>=<duration>,...")
+	flag.String("workflow-retry", "", "how many times failed argo workflows are retried before the error status. <kind>=<attempts>,...")
+	flag.Int("workflow-retry-backoff-sec", 60, "wait before the second retry of a failed argo workflow. it doubles on every retry")
+	flag.String("event-sinks", event.SinkLog, "sinks of status change events. comma separated list of log, tks-api and webhook")
+	flag.String("event-tks-api-path", "", "tks-api path to post status change events to. required for the tks-api sink. the endpoint must accept an event as a JSON body and answer 2xx")
+	flag.String("event-webhook-url", "", "url to post status change events to")
+	flag.String("event-webhook-secret", "", "secret to sign webhook bodies with HMAC-SHA256. not signed if empty")
+	flag.Int("event-retry-max", 5, "attempts to deliver an event to a sink before recording it as a dead letter")
+	flag.Int("event-retry-backoff-sec", 2, "wait before the second delivery attempt of an event. it doubles on every attempt")
 	flag.String("tks-api-address", "http://tks-api.tks.svc", "server address for tks-api")
 	flag.Int("tks-api-port", 9110, "server port number for tks-api")
 	flag.String("tks-api-account", "admin", "account name for tks-api")
@@ -49,20 +90,93 @@ func init() {
 	flag.String("dbpassword", "password", "password for postgreSQL user")
 	flag.String("dbname", "tks", "the name of database")
 
+	flag.String("leader-elect", leader.ModeNone, "leader election for running multiple replicas. one of none, lease and postgres")
+	flag.String("leader-election-namespace", "tks", "namespace of the lease for leader election")
+	flag.String("leader-election-name", "tks-batch", "name of the lease for leader election")
+	flag.Int("leader-election-lease-sec", 15, "duration that non-leaders wait before taking over the leadership")
+	flag.Int("leader-election-renew-sec", 10, "duration that the leader retries refreshing the leadership before giving up")
+	flag.Int("leader-election-retry-sec", 2, "interval between attempts to acquire or renew the leadership")
+	flag.Int64("leader-election-lock-key", 7311, "key of postgreSQL advisory lock for leader election")
+
+	flag.String("rule-group-interval", "", "evaluation interval of the rule groups. the interval of thanos-ruler if empty")
+	flag.Int("rule-group-limit", 0, "limit of the alerts of a rule group. 0 is no limit")
+	flag.String("rule-group-intervals", "", "evaluation intervals by template. <template id or name>=<duration>,...")
+	flag.String("rule-group-limits", "", "limits by template. <template id or name>=<limit>,...")
+	flag.String("thanos-ruler-namespace", "lma", "namespace of thanos-ruler in a cluster")
+	flag.String("thanos-ruler-configmap", "thanos-ruler-configmap", "ConfigMap of the rules of thanos-ruler")
+	flag.String("thanos-ruler-rule-file", RULER_FILE_NAME, "key of the rules of tks-batch in the ConfigMap")
+	flag.String("thanos-ruler-service", "thanos-ruler", "service of thanos-ruler")
+	flag.String("thanos-ruler-statefulset", "thanos-ruler", "StatefulSet of thanos-ruler for the rollout reload")
+	flag.String("thanos-ruler-endpoint-secret", "tks-endpoint-secret", "secret with the thanos-ruler address in the namespace of a cluster")
+	flag.String("thanos-ruler-layout-configmap", "tks-thanos-ruler-layout", "ConfigMap in the namespace of a cluster which overrides namespace, configmap, rule-file, service, statefulset and endpoint-secret of thanos-ruler. not used if empty")
+	flag.String("thanos-ruler-discovery", DEFAULT_THANOS_RULER_DISCOVERY, "strategies to discover the url of thanos-ruler in order. comma separated list of override, endpoint-secret, ingress, route, loadbalancer, nodeport and proxy")
+	flag.String("thanos-ruler-urls", "", "urls of thanos-ruler which override the discovery. <organization>=<url>,...")
+	flag.Int("thanos-ruler-timeout-sec", 30, "timeout of a request to thanos-ruler")
+	flag.Int("thanos-ruler-retry-max", 3, "attempts of a request to thanos-ruler on connection errors and 5xx responses")
+	flag.Int("thanos-ruler-retry-backoff-sec", 1, "wait before the second attempt of a request to thanos-ruler. it doubles on every attempt")
+	flag.String("thanos-ruler-ca-file", "", "path of a PEM CA bundle to verify thanos-ruler with")
+	flag.String("thanos-ruler-cert-file", "", "path of a PEM client certificate for thanos-ruler")
+	flag.String("thanos-ruler-key-file", "", "path of the PEM key of thanos-ruler-cert-file")
+	flag.String("thanos-ruler-auth-secret", "tks-thanos-ruler-auth", "secret in the namespace of a cluster with ca.crt, tls.crt, tls.key, token, username, password and insecure-skip-verify for thanos-ruler. not used if empty")
+	flag.String("thanos-ruler-reload", ReloadHttp, "how thanos-ruler reloads the rules. one of http, rollout and sidecar")
+	flag.String("thanos-ruler-reloads", "", "reload strategies by organization. <organization>=<strategy>,...")
+	flag.Int("thanos-ruler-reload-backoff-sec", 10, "wait before retrying a failed reload of thanos-ruler. it doubles on every failure")
+	flag.Int("thanos-ruler-reload-wait-sec", 120, "wait for thanos-ruler to load the applied rules, which takes until the kubelet updates the mounted ConfigMap, before they are reported as not loaded and the reload is retried with backoff")
+	flag.String("organization", "", "organization id for the rules render command")
+
+	initProcessorFlags()
+}
+
+// parseFlags parses the command and the flags. It is not called by init so that the flags of go test are not parsed.
+// The words of the command may come before, between or after the flags.
+func parseFlags() {
+	command = parseCommand(flag.CommandLine, os.Args[1:])
+
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
-	flag.Parse()
 	if err := viper.BindPFlags(pflag.CommandLine); err != nil {
 		log.Error(context.TODO(), "Failed to bindFlags ", err)
 	}
+}
 
+// parseCommand parses the flags in args into fs and returns the other arguments, which are the words of the command.
+// fs stops parsing at the first non-flag argument, so the flags after it are parsed again.
+func parseCommand(fs *flag.FlagSet, args []string) []string {
+	var words []string
+	for len(args) > 0 {
+		for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			words = append(words, args[0])
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			break
+		}
+		if err := fs.Parse(args); err != nil {
+			log.Error(context.TODO(), "Failed to parse flags ", err)
+			break
+		}
+		args = fs.Args()
+	}
+	return words
 }
 
 func main() {
-	log.Info(context.TODO(), "*** Arguments *** ")
-	for i, s := range viper.AllSettings() {
-		log.Info(context.TODO(), fmt.Sprintf("%s : %v", i, s))
+	parseFlags()
+
+	// the output of a command may be redirected to a file, so the logs and the arguments with the passwords are kept out of it
+	commandOut := os.Stdout
+	if len(command) > 0 {
+		out, err := separateCommandOutput()
+		if err != nil {
+			log.Fatal(context.TODO(), "failed to separate the output of the command : ", err)
+		}
+		commandOut = out
+	} else {
+		log.Info(context.TODO(), "*** Arguments *** ")
+		for i, s := range viper.AllSettings() {
+			log.Info(context.TODO(), fmt.Sprintf("%s : %v", i, s))
+		}
+		log.Info(context.TODO(), "****************** ")
 	}
-	log.Info(context.TODO(), "****************** ")
 
 	// Initialize database
 	db, err := database.InitDB()
@@ -74,49 +188,141 @@ func main() {
 	cloudAccountAccessor = cloudAccount.New(db)
 	organizationAccessor = organization.New(db)
 	systemNotificationRuleAccessor = systemNotificationRule.New(db)
+	ruleGroups, err = parseRuleGroupConfig(viper.GetString("rule-group-interval"), viper.GetInt("rule-group-limit"),
+		viper.GetString("rule-group-intervals"), viper.GetString("rule-group-limits"))
+	if err != nil {
+		log.Fatal(context.TODO(), "invalid rule group config : ", err)
+	}
+	reloads, err = parseReloadConfig(viper.GetString("thanos-ruler-reload"), viper.GetString("thanos-ruler-reloads"))
+	if err != nil {
+		log.Fatal(context.TODO(), "invalid reload config : ", err)
+	}
+	cache = gcache.New(5*time.Minute, 10*time.Minute)
+
+	if len(command) > 0 {
+		if err = runCommand(context.Background(), command, commandOut); err != nil {
+			log.Fatal(context.TODO(), err)
+		}
+		return
+	}
+
+	historyAccessor = history.New(db)
+	if err = historyAccessor.Migrate(); err != nil {
+		log.Fatal(context.TODO(), "failed to migrate status history : ", err)
+	}
+	ruleStatusAccessor = ruleStatus.New(db)
+	if err = ruleStatusAccessor.Migrate(); err != nil {
+		log.Fatal(context.TODO(), "failed to migrate rule status : ", err)
+	}
+	rulerReloadAccessor = rulerReload.New(db)
+	if err = rulerReloadAccessor.Migrate(); err != nil {
+		log.Fatal(context.TODO(), "failed to migrate ruler reload : ", err)
+	}
+	workflowRetryAccessor = workflowRetry.New(db)
+	if err = workflowRetryAccessor.Migrate(); err != nil {
+		log.Fatal(context.TODO(), "failed to migrate workflow retry : ", err)
+	}
+	statusEntryAccessor = statusEntry.New(db)
+	if err = statusEntryAccessor.Migrate(); err != nil {
+		log.Fatal(context.TODO(), "failed to migrate status entry : ", err)
+	}
 
 	// initialize external clients
 	argowfClient, err = argo.New(viper.GetString("argo-address"), viper.GetInt("argo-port"), false, "")
 	if err != nil {
 		log.Fatal(context.TODO(), "failed to create argowf client : ", err)
 	}
+	argowfClient = metrics.InstrumentArgoClient(argowfClient)
+	workflowSource, err := workflow.NewSource(viper.GetString("argo-workflow-source"), argowfClient,
+		fmt.Sprintf("%s:%d", viper.GetString("argo-address"), viper.GetInt("argo-port")), "argo", time.Second*INTERVAL_SEC)
+	if err != nil {
+		log.Fatal(context.TODO(), "failed to create argo workflow source : ", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	workflowWatcher, _ = workflowSource.(*workflow.Watcher)
+	if workflowWatcher != nil {
+		go workflowWatcher.Run(ctx)
+	}
+	statusReconciler = reconciler.New(workflowSource, "argo")
+	timeouts, err := parseStuckTimeouts(viper.GetString("stuck-timeouts"))
+	if err != nil {
+		log.Fatal(context.TODO(), "invalid stuck-timeouts : ", err)
+	}
+	statusReconciler.SetTimeouts(timeouts, statusEntryAccessor)
+	retryPolicies, err := parseRetryPolicies(viper.GetString("workflow-retry"), time.Second*time.Duration(viper.GetInt("workflow-retry-backoff-sec")))
+	if err != nil {
+		log.Fatal(context.TODO(), "invalid workflow-retry : ", err)
+	}
+	statusReconciler.SetRetry(retryPolicies,
+		workflow.NewRetryClient(fmt.Sprintf("%s:%d", viper.GetString("argo-address"), viper.GetInt("argo-port"))), workflowRetryAccessor)
+	statusReconciler.Observe(recordStatusHistory)
+	reconciler.Register(statusReconciler, clusterKind)
+	reconciler.Register(statusReconciler, appGroupKind)
+	reconciler.Register(statusReconciler, organizationKind)
+	reconciler.Register(statusReconciler, cloudAccountKind)
 	apiClient, err = _apiClient.New(fmt.Sprintf("%s:%d", viper.GetString("tks-api-address"), viper.GetInt("tks-api-port")))
 	if err != nil {
 		log.Fatal(context.TODO(), "failed to create tks-api client : ", err)
 	}
+	apiClient = metrics.InstrumentApiClient(apiClient)
 
-	cache = gcache.New(5*time.Minute, 10*time.Minute)
+	deadLetterAccessor := event.New(db)
+	if err = deadLetterAccessor.Migrate(); err != nil {
+		log.Fatal(context.TODO(), "failed to migrate event dead letter : ", err)
+	}
+	eventPublisher, err = newEventPublisher(deadLetterAccessor)
+	if err != nil {
+		log.Fatal(context.TODO(), "failed to create event publisher : ", err)
+	}
+	// the publisher outlives the processors so that their last events are delivered or recorded as dead letters
+	publisherCtx, stopPublisher := context.WithCancel(context.WithoutCancel(ctx))
+	publisherDone := make(chan struct{})
+	go func() {
+		defer close(publisherDone)
+		eventPublisher.Run(publisherCtx)
+	}()
+	statusReconciler.Observe(publishStatusEvent)
 
-	for {
-		err = processClusterStatus()
-		if err != nil {
-			log.Error(context.TODO(), err)
-		}
-		err = processAppGroupStatus()
-		if err != nil {
-			log.Error(context.TODO(), err)
-		}
-		err = processCloudAccountStatus()
-		if err != nil {
-			log.Error(context.TODO(), err)
-		}
-		err = processOrganizationStatus()
-		if err != nil {
-			log.Error(context.TODO(), err)
-		}
-		err = processClusterByoh()
-		if err != nil {
-			log.Error(context.TODO(), err)
-		}
-		err = processSystemNotificationRule()
-		if err != nil {
-			log.Error(context.TODO(), err)
+	jobScheduler = newScheduler()
+
+	server := newHttpServer(db)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(context.TODO(), "failed to serve http : ", err)
 		}
-		err = processReloadThanosRules()
-		if err != nil {
+	}()
+	defer func() {
+		if err := server.Shutdown(context.Background()); err != nil {
 			log.Error(context.TODO(), err)
 		}
-		time.Sleep(time.Second * INTERVAL_SEC)
-	}
+	}()
 
+	identity := os.Getenv("POD_NAME")
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+	err = leader.Run(ctx, leader.Config{
+		Mode:          viper.GetString("leader-elect"),
+		Identity:      identity,
+		Namespace:     viper.GetString("leader-election-namespace"),
+		Name:          viper.GetString("leader-election-name"),
+		LeaseDuration: time.Second * time.Duration(viper.GetInt("leader-election-lease-sec")),
+		RenewDeadline: time.Second * time.Duration(viper.GetInt("leader-election-renew-sec")),
+		RetryPeriod:   time.Second * time.Duration(viper.GetInt("leader-election-retry-sec")),
+		LockKey:       viper.GetInt64("leader-election-lock-key"),
+	}, db, func(ctx context.Context) {
+		jobScheduler.Run(ctx)
+	})
+	stopPublisher()
+	<-publisherDone
+	if err != nil {
+		log.Fatal(context.TODO(), "failed to run leader election : ", err)
+	}
+	if ctx.Err() == nil {
+		// exit so that this instance comes back as a standby
+		log.Fatal(context.TODO(), "lost the leadership")
+	}
+	log.Info(context.TODO(), "tks-batch stopped")
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/openinfradev/tks-api/pkg/log"
+	"github.com/openinfradev/tks-batch/internal/metrics"
+	"github.com/openinfradev/tks-batch/internal/scheduler"
+	"github.com/spf13/viper"
+)
+
+type processor struct {
+	name        string
+	intervalSec int
+	timeoutSec  int
+	run         func(ctx context.Context) error
+}
+
+var processors = []processor{
+	{"cluster-status", INTERVAL_SEC, 60, onWorkflowChange(processClusterStatus)},
+	{"appgroup-status", INTERVAL_SEC, 60, onWorkflowChange(processAppGroupStatus)},
+	{"cloud-account-status", INTERVAL_SEC, 60, onWorkflowChange(processCloudAccountStatus)},
+	{"organization-status", INTERVAL_SEC, 60, onWorkflowChange(processOrganizationStatus)},
+	{"cluster-byoh", INTERVAL_SEC, 60, processClusterByoh},
+	{"system-notification-rule", INTERVAL_SEC, 120, processSystemNotificationRule},
+	{"thanos-reload", INTERVAL_SEC, 60, processReloadThanosRules},
+	{"status-history-retention", 3600, 300, processStatusHistoryRetention},
+}
+
+func initProcessorFlags() {
+	for _, p := range processors {
+		flag.Bool(p.name+"-enabled", true, "enable "+p.name+" processor")
+		flag.Int(p.name+"-interval-sec", p.intervalSec, "interval of "+p.name+" processor")
+		flag.Int(p.name+"-timeout-sec", p.timeoutSec, "timeout of a single run of "+p.name+" processor")
+	}
+}
+
+func newScheduler() *scheduler.Scheduler {
+	s := scheduler.New()
+	for _, p := range processors {
+		if !viper.GetBool(p.name + "-enabled") {
+			continue
+		}
+		s.Add(scheduler.Job{
+			Name:     p.name,
+			Interval: positiveSeconds(p.name + "-interval-sec"),
+			Timeout:  positiveSeconds(p.name + "-timeout-sec"),
+			Run:      instrument(p.name, p.run),
+		})
+	}
+	return s
+}
+
+// positiveSeconds returns the duration of the flag in seconds. It exits if the duration is not positive.
+func positiveSeconds(name string) time.Duration {
+	sec := viper.GetInt(name)
+	if sec <= 0 {
+		log.Fatal(context.TODO(), fmt.Sprintf("invalid %s : %d. it must be positive", name, sec))
+	}
+	return time.Second * time.Duration(sec)
+}
+
+func instrument(name string, run func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		start := time.Now()
+		err := run(ctx)
+		metrics.ProcessorRuns.WithLabelValues(name).Inc()
+		metrics.ProcessorDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+		if err != nil {
+			metrics.ProcessorErrors.WithLabelValues(name).Inc()
+		}
+		return err
+	}
+}
+
+// onWorkflowChange skips the run in watch mode while no workflow has changed,
+// except that everything is reconciled once per argo-resync-sec.
+func onWorkflowChange(run func(ctx context.Context) error) func(ctx context.Context) error {
+	var generation uint64
+	var lastRun time.Time
+	return func(ctx context.Context) error {
+		if workflowWatcher != nil {
+			g := workflowWatcher.Generation()
+			if g == generation && time.Since(lastRun) < time.Second*time.Duration(viper.GetInt("argo-resync-sec")) {
+				return nil
+			}
+			generation = g
+		}
+		lastRun = time.Now()
+		return run(ctx)
+	}
+}
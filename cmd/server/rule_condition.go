@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openinfradev/tks-api/pkg/domain"
+)
+
+// comparison operators of PromQL allowed in a condition parameter
+var allowedOperators = map[string]bool{
+	"==": true,
+	"!=": true,
+	">":  true,
+	"<":  true,
+	">=": true,
+	"<=": true,
+}
+
+// Conjunctions between condition parameters
+const (
+	CONJUNCTION_AND = "and"
+	CONJUNCTION_OR  = "or"
+)
+
+// conditionParameter is a threshold of a condition.
+// Conjunction joins it with the previous parameter and is "and" if empty.
+type conditionParameter struct {
+	domain.SystemNotificationParameter
+	Conjunction string `json:"conjunction,omitempty"`
+}
+
+// compileCondition returns the expression of metricQuery compared with every parameter in order.
+// A rule without any threshold would fire constantly, so at least one parameter is required.
+func compileCondition(metricQuery string, rawParameters []byte) (string, error) {
+	if strings.TrimSpace(metricQuery) == "" {
+		return "", fmt.Errorf("empty metric query")
+	}
+
+	var parameters []conditionParameter
+	if len(rawParameters) > 0 {
+		if err := json.Unmarshal(rawParameters, &parameters); err != nil {
+			return "", fmt.Errorf("invalid parameters. err : %s", err)
+		}
+	}
+	if len(parameters) == 0 {
+		return "", fmt.Errorf("no parameter")
+	}
+	sort.SliceStable(parameters, func(i, j int) bool {
+		return parameters[i].Order < parameters[j].Order
+	})
+
+	terms := make([]string, len(parameters))
+	for i, parameter := range parameters {
+		operator := strings.TrimSpace(parameter.Operator)
+		if !allowedOperators[operator] {
+			return "", fmt.Errorf("operator [%s] of parameter %d is not allowed", parameter.Operator, parameter.Order)
+		}
+		value := strings.TrimSpace(parameter.Value)
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "", fmt.Errorf("value [%s] of parameter %d is not a number", parameter.Value, parameter.Order)
+		}
+		terms[i] = fmt.Sprintf("%s %s %s", metricQuery, operator, value)
+	}
+
+	// keep the expression of a single parameter as it was
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+
+	// "and" binds tighter than "or" in PromQL
+	expr := "(" + terms[0] + ")"
+	for i := 1; i < len(terms); i++ {
+		conjunction := strings.ToLower(strings.TrimSpace(parameters[i].Conjunction))
+		switch conjunction {
+		case "":
+			conjunction = CONJUNCTION_AND
+		case CONJUNCTION_AND, CONJUNCTION_OR:
+		default:
+			return "", fmt.Errorf("conjunction [%s] of parameter %d is not allowed", parameters[i].Conjunction, parameters[i].Order)
+		}
+		expr = fmt.Sprintf("%s %s (%s)", expr, conjunction, terms[i])
+	}
+	return expr, nil
+}
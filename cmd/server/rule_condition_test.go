@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileCondition(t *testing.T) {
+	testCases := []struct {
+		name        string
+		metricQuery string
+		parameters  string
+		want        string
+		wantErr     bool
+	}{
+		{
+			name:        "single parameter",
+			metricQuery: "node_load1",
+			parameters:  `[{"order":0,"operator":">","value":"3"}]`,
+			want:        "node_load1 > 3",
+		},
+		{
+			name:        "operator and value are trimmed",
+			metricQuery: "node_load1",
+			parameters:  `[{"order":0,"operator":" >= ","value":" 0.5 "}]`,
+			want:        "node_load1 >= 0.5",
+		},
+		{
+			name:        "and by default",
+			metricQuery: "up",
+			parameters:  `[{"order":0,"operator":">","value":"1"},{"order":1,"operator":"<","value":"10"}]`,
+			want:        "(up > 1) and (up < 10)",
+		},
+		{
+			name:        "or",
+			metricQuery: "up",
+			parameters:  `[{"order":0,"operator":"<","value":"1"},{"order":1,"operator":">","value":"10","conjunction":"OR"}]`,
+			want:        "(up < 1) or (up > 10)",
+		},
+		{
+			name:        "sorted by order",
+			metricQuery: "up",
+			parameters:  `[{"order":2,"operator":"!=","value":"5"},{"order":1,"operator":"==","value":"1"}]`,
+			want:        "(up == 1) and (up != 5)",
+		},
+		{
+			name:        "conjunction of the first parameter is ignored",
+			metricQuery: "up",
+			parameters:  `[{"order":0,"operator":">","value":"1","conjunction":"unless"},{"order":1,"operator":"<","value":"3"}]`,
+			want:        "(up > 1) and (up < 3)",
+		},
+		{
+			name:        "empty metric query",
+			metricQuery: " ",
+			parameters:  `[{"order":0,"operator":">","value":"3"}]`,
+			wantErr:     true,
+		},
+		{
+			name:        "no parameter",
+			metricQuery: "up",
+			parameters:  `[]`,
+			wantErr:     true,
+		},
+		{
+			name:        "nil parameters",
+			metricQuery: "up",
+			wantErr:     true,
+		},
+		{
+			name:        "invalid json",
+			metricQuery: "up",
+			parameters:  `{"order":0}`,
+			wantErr:     true,
+		},
+		{
+			name:        "operator not allowed",
+			metricQuery: "up",
+			parameters:  `[{"order":0,"operator":"+","value":"3"}]`,
+			wantErr:     true,
+		},
+		{
+			name:        "value is not a number",
+			metricQuery: "up",
+			parameters:  `[{"order":0,"operator":">","value":"3 or vector(1)"}]`,
+			wantErr:     true,
+		},
+		{
+			name:        "conjunction not allowed",
+			metricQuery: "up",
+			parameters:  `[{"order":0,"operator":">","value":"1"},{"order":1,"operator":"<","value":"3","conjunction":"unless"}]`,
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var parameters []byte
+			if tc.parameters != "" {
+				parameters = []byte(tc.parameters)
+			}
+			got, err := compileCondition(tc.metricQuery, parameters)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"text/template"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// TEMPLATE_PREFIX defines the variables which thanos-ruler provides to the templates of a rule.
+const TEMPLATE_PREFIX = "{{$labels := .Labels}}{{$externalLabels := .ExternalLabels}}{{$externalURL := .ExternalURL}}{{$value := .Value}}"
+
+// templateFuncs has the names of the functions which thanos-ruler provides to templates.
+// Only the names matter to parse a template.
+var templateFuncs = template.FuncMap{}
+
+func init() {
+	for _, name := range []string{
+		"query", "first", "label", "value", "strvalue", "args", "reReplaceAll", "safeHtml", "match",
+		"title", "toUpper", "toLower", "graphLink", "tableLink", "sortByLabel",
+		"humanize", "humanize1024", "humanizeDuration", "humanizePercentage", "humanizeTimestamp",
+		"toTime", "toDuration", "now", "pathPrefix", "externalURL", "parseDuration", "stripPort", "stripDomain",
+	} {
+		templateFuncs[name] = func(...interface{}) interface{} { return nil }
+	}
+}
+
+// validateRule returns an error if thanos-ruler would fail to load the rule.
+func validateRule(rule Rule) error {
+	if rule.Alert == "" {
+		return fmt.Errorf("empty alert name")
+	}
+	if _, err := parser.ParseExpr(rule.Expr); err != nil {
+		return fmt.Errorf("invalid expr [%s]. err : %s", rule.Expr, err)
+	}
+	if rule.For != "" {
+		if _, err := model.ParseDuration(rule.For); err != nil {
+			return fmt.Errorf("invalid for [%s]. err : %s", rule.For, err)
+		}
+	}
+
+	templates := map[string]string{
+		"CheckPoint":         rule.Annotations.CheckPoint,
+		"description":        rule.Annotations.Description,
+		"discriminative":     rule.Annotations.Discriminative,
+		"message":            rule.Annotations.Message,
+		"summary":            rule.Annotations.Summary,
+		"policyName":         rule.Annotations.PolicyName,
+		"policyTemplateName": rule.Annotations.PolicyTemplateName,
+		"severity":           rule.Labels.Severity,
+	}
+	for name, text := range templates {
+		if _, err := template.New(name).Funcs(templateFuncs).Parse(TEMPLATE_PREFIX + text); err != nil {
+			return fmt.Errorf("invalid template of %s. err : %s", name, err)
+		}
+	}
+	return nil
+}
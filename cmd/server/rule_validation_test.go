@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The expressions are a regression suite of the PromQL parser of the prometheus version in go.mod,
+// which should not accept what the engine of thanos-ruler refuses to load.
+func TestValidateRuleExpr(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		valid bool
+	}{
+		// selectors
+		{"metric", `up`, true},
+		{"matchers", `node_cpu_seconds_total{mode!="idle",cpu=~"0|1"}`, true},
+		{"name matcher", `{__name__="up"}`, true},
+		{"metric with colon", `job:http_requests:rate5m`, true},
+		{"trailing comma", `up{job="a",}`, true},
+		{"comment", "up # the targets", true},
+		{"empty selector", `{}`, false},
+		{"only empty matcher", `{job=""}`, false},
+		{"invalid regexp", `up{job=~"("}`, false},
+		{"unterminated selector", `up{job="a"`, false},
+		{"label value without quotes", `up{job=a}`, false},
+
+		// numbers and strings
+		{"integer", `1`, true},
+		{"float", `.5`, true},
+		{"exponent", `1e3`, true},
+		{"hex", `0x1f`, true},
+		{"underscores", `1_000`, false},
+		{"underscores in float", `1_000.5`, false},
+		{"underscore after hex prefix", `0x_1f`, false},
+		{"inf", `Inf`, true},
+		{"nan", `NaN`, true},
+		{"negative", `-1`, true},
+		{"string", `"value"`, true},
+		{"raw string", "`value`", true},
+		{"double underscores", `1__000`, false},
+		{"trailing underscore", `1_`, false},
+		{"bad exponent", `1e`, false},
+		{"unterminated string", `"value`, false},
+
+		// ranges, subqueries and modifiers
+		{"range", `rate(http_requests_total[5m])`, true},
+		{"compound duration", `rate(http_requests_total[1h30m])`, true},
+		{"subquery", `max_over_time(rate(http_requests_total[5m])[1h:1m])`, true},
+		{"subquery without step", `max_over_time(up[1h:])`, true},
+		{"offset", `up offset 5m`, true},
+		{"negative offset", `up offset -5m`, true},
+		{"at timestamp", `up @ 1609746000`, true},
+		{"at start", `rate(up[5m] @ start())`, true},
+		{"invalid duration", `rate(up[5x])`, false},
+		{"range of range", `up[5m][5m]`, false},
+		{"range of expression", `(up)[5m]`, false},
+		{"subquery with two steps", `up[1h:1m:1m]`, false},
+		{"offset without duration", `up offset`, false},
+		{"offset of a function", `rate(up[5m]) offset 5m`, false},
+		{"at without parens", `up @ start`, false},
+
+		// operators
+		{"comparison", `up == 0`, true},
+		{"bool between scalars", `1 > bool 2`, true},
+		{"vector matching", `a / on(instance) group_left(node) b`, true},
+		{"group left without labels", `a * ignoring(job) group_left b`, true},
+		{"set operator", `a unless on(job) b`, true},
+		{"right associative power", `2 ^ 3 ^ 2`, true},
+		{"unary on a vector", `-up`, true},
+		{"comparison between scalars", `1 > 2`, false},
+		{"set operator with a scalar", `up and 1`, false},
+		{"grouping of set operator", `a and on(job) group_left b`, false},
+		{"bool of arithmetic", `a + bool b`, false},
+		{"missing operand", `up ==`, false},
+		{"unary on a string", `-"a"`, false},
+		{"range in binary", `up[5m] > 1`, false},
+
+		// aggregations and functions
+		{"aggregation by", `sum by (job) (rate(http_requests_total[5m])) > 1000`, true},
+		{"aggregation by after", `sum(up) without (instance)`, true},
+		{"parameter", `topk(3, up)`, true},
+		{"count values", `count_values("value", up)`, true},
+		{"histogram quantile", `histogram_quantile(0.9, sum by (le) (rate(h_bucket[5m])))`, true},
+		{"label replace", `label_replace(up, "a", "$1", "b", "(.*)")`, true},
+		{"info", `info(up)`, false},
+		{"info with selector", `info(up, {k8s_cluster_name=~".+"})`, false},
+		{"no argument", `time() - process_start_time_seconds > 60`, true},
+		{"optional argument", `round(up, 0.5)`, true},
+		{"two groupings", `sum without (a) (up) by (b)`, false},
+		{"missing parameter", `topk(up)`, false},
+		{"scalar in aggregation", `sum(1)`, false},
+		{"unknown function", `foo(up)`, false},
+		{"instant vector for range", `rate(up)`, false},
+		{"range vector for instant", `abs(up[5m])`, false},
+		{"wrong number of arguments", `label_replace(up)`, false},
+		{"unclosed call", `sum(up`, false},
+
+		{"empty", ``, false},
+		{"trailing tokens", `up up`, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateRule(Rule{Alert: "test", Expr: tc.input})
+			if tc.valid {
+				require.NoError(t, err, tc.input)
+			} else {
+				require.Error(t, err, tc.input)
+			}
+		})
+	}
+}
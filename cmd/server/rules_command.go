@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/openinfradev/tks-api/pkg/log"
+	"github.com/spf13/viper"
+)
+
+const RULES_RENDER_USAGE = "usage: tks-batch rules render --organization <organization id> [flags]"
+
+// runCommand runs a subcommand such as "rules render" instead of the server.
+// The result is written to out.
+func runCommand(ctx context.Context, command []string, out io.Writer) error {
+	if len(command) != 2 || command[0] != "rules" || command[1] != "render" {
+		return fmt.Errorf("unknown command %v. %s", command, RULES_RENDER_USAGE)
+	}
+	return renderOrganizationRules(ctx, out, viper.GetString("organization"))
+}
+
+// renderOrganizationRules prints ruler-user.yml which tks-batch would write for the organization
+// and its diff against the current ConfigMap. It writes nothing.
+func renderOrganizationRules(ctx context.Context, out io.Writer, organizationId string) error {
+	if organizationId == "" {
+		return fmt.Errorf("organization is required. %s", RULES_RENDER_USAGE)
+	}
+
+	organization, err := organizationAccessor.Get(organizationId)
+	if err != nil {
+		return err
+	}
+	if organization.PrimaryClusterId == "" {
+		return fmt.Errorf("invalid primary cluster for organization %s", organizationId)
+	}
+
+	systemNotificationRules, err := systemNotificationRuleAccessor.GetRules(organizationId)
+	if err != nil {
+		return err
+	}
+	config, results := buildRulerConfig(systemNotificationRules)
+	for _, result := range results {
+		if result.err != nil {
+			log.Error(ctx, fmt.Sprintf("Excluded invalid rule %s [%s]. err : ", result.rule.Name, result.rule.ID), result.err)
+		}
+	}
+
+	_, cm, layout, err := getRulerConfigMap(ctx, organization.PrimaryClusterId)
+	if err != nil {
+		return err
+	}
+	current := cm.Data[layout.RuleFile]
+	rendered, err := renderRules(current, config)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "# %s of organization %s in cluster %s (%s/%s)\n", layout.RuleFile, organizationId, organization.PrimaryClusterId, layout.Namespace, layout.ConfigMap)
+	fmt.Fprint(out, rendered)
+
+	diff := unifiedDiff(current, rendered, "current/"+layout.RuleFile, "rendered/"+layout.RuleFile)
+	if diff == "" {
+		fmt.Fprintln(out, "\n# no changes against the current ConfigMap")
+		return nil
+	}
+	fmt.Fprintf(out, "\n# diff against the current ConfigMap\n%s", diff)
+	return nil
+}
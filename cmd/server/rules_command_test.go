@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCommand(t *testing.T) {
+	testCases := []struct {
+		name         string
+		args         []string
+		want         []string
+		organization string
+	}{
+		{"no command", []string{"--organization", "o1"}, nil, "o1"},
+		{"command before the flags", []string{"rules", "render", "--organization", "o1"}, []string{"rules", "render"}, "o1"},
+		{"command after the flags", []string{"--organization", "o1", "rules", "render"}, []string{"rules", "render"}, "o1"},
+		{"command between the flags", []string{"rules", "--organization=o1", "render", "--port", "1"}, []string{"rules", "render"}, "o1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			organization := fs.String("organization", "", "")
+			fs.Int("port", 0, "")
+
+			require.Equal(t, tc.want, parseCommand(fs, tc.args))
+			require.Equal(t, tc.organization, *organization)
+		})
+	}
+}
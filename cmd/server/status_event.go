@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openinfradev/tks-batch/internal/event"
+	"github.com/openinfradev/tks-batch/internal/reconciler"
+	"github.com/spf13/viper"
+)
+
+func newEventPublisher(deadLetters *event.DeadLetterAccessor) (*event.Publisher, error) {
+	var sinks []event.Sink
+	for _, name := range strings.Split(viper.GetString("event-sinks"), ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case event.SinkLog:
+			sinks = append(sinks, event.NewLogSink())
+		case event.SinkTksApi:
+			if viper.GetString("event-tks-api-path") == "" {
+				return nil, fmt.Errorf("event-tks-api-path is required for the tks-api sink")
+			}
+			sinks = append(sinks, event.NewApiSink(apiClient, viper.GetString("event-tks-api-path"), getTksApiToken))
+		case event.SinkWebhook:
+			if viper.GetString("event-webhook-url") == "" {
+				return nil, fmt.Errorf("event-webhook-url is required for the webhook sink")
+			}
+			sinks = append(sinks, event.NewWebhookSink(viper.GetString("event-webhook-url"), viper.GetString("event-webhook-secret")))
+		default:
+			return nil, fmt.Errorf("unknown event sink [%s]", name)
+		}
+	}
+	return event.NewPublisher(sinks, deadLetters,
+		viper.GetInt("event-retry-max"), time.Second*time.Duration(viper.GetInt("event-retry-backoff-sec"))), nil
+}
+
+func publishStatusEvent(ctx context.Context, e reconciler.Event) {
+	eventPublisher.Publish(ctx, event.Event{
+		Kind:           e.Kind,
+		EntityId:       e.EntityId,
+		OrganizationId: e.OrganizationId,
+		OldStatus:      e.OldStatus,
+		NewStatus:      e.NewStatus,
+		Message:        e.Message,
+		WorkflowId:     e.WorkflowId,
+	})
+}
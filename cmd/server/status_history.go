@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/openinfradev/tks-api/pkg/log"
+	"github.com/openinfradev/tks-batch/internal/history"
+	"github.com/openinfradev/tks-batch/internal/reconciler"
+	"github.com/openinfradev/tks-batch/internal/scheduler"
+	"github.com/spf13/viper"
+)
+
+func recordStatusHistory(ctx context.Context, e reconciler.Event) {
+	err := historyAccessor.Record(history.StatusHistory{
+		Kind:       e.Kind,
+		EntityId:   e.EntityId,
+		OldStatus:  e.OldStatus,
+		NewStatus:  e.NewStatus,
+		Message:    e.Message,
+		WorkflowId: e.WorkflowId,
+		Processor:  scheduler.JobName(ctx),
+	})
+	if err != nil {
+		log.Error(ctx, fmt.Sprintf("Failed to record status history of %s [%s] err : ", e.Kind, e.EntityId), err)
+	}
+}
+
+func processStatusHistoryRetention(ctx context.Context) error {
+	retention := time.Hour * 24 * time.Duration(viper.GetInt("status-history-retention-days"))
+	if retention <= 0 {
+		return nil
+	}
+	deleted, err := historyAccessor.DeleteOlderThan(retention)
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		log.Info(ctx, fmt.Sprintf("[processStatusHistoryRetention] deleted status histories : %d", deleted))
+	}
+	return nil
+}
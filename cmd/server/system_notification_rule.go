@@ -2,20 +2,37 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/gofrs/uuid"
 	"github.com/openinfradev/tks-api/pkg/domain"
 	"github.com/openinfradev/tks-api/pkg/kubernetes"
 	"github.com/openinfradev/tks-api/pkg/log"
+	"github.com/openinfradev/tks-batch/internal/metrics"
+	ruleStatus "github.com/openinfradev/tks-batch/internal/rule-status"
 	systemNotification "github.com/openinfradev/tks-batch/internal/system-notification-rule"
 	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
 )
 
+// RULER_FILE_NAME is the default key of the rules in the ConfigMap of thanos-ruler.
 const RULER_FILE_NAME = "ruler-user.yml"
 
+// Rule groups generated by tks-batch are named "tks-<template id>".
+// TKS_GROUP_NAME is used when an organization has no rule.
+const TKS_GROUP_NAME = "tks"
+
+// RULES_HASH_ANNOTATION of the ConfigMap has the sha256 of ruler-user.yml written by tks-batch.
+const RULES_HASH_ANNOTATION = "tks-batch.openinfradev.github.io/rules-hash"
+
 type RuleAnnotation struct {
 	CheckPoint               string `yaml:"CheckPoint"`
 	Description              string `yaml:"description"`
@@ -26,10 +43,15 @@ type RuleAnnotation struct {
 	SystemNotificationRuleId string `yaml:"systemNotificationRuleId,omitempty"`
 	PolicyName               string `yaml:"policyName,omitempty"`
 	PolicyTemplateName       string `yaml:"policyTemplateName,omitempty"`
+	// ContentHash is the hash of the rules of the notification rule, so that the loaded version can be verified.
+	ContentHash string `yaml:"contentHash,omitempty"`
 }
 
+// RuleLabels of the rules of a notification rule share systemNotificationRuleId,
+// so that alertmanager can inhibit a lower severity with equal: [alertname, systemNotificationRuleId].
 type RuleLabels struct {
-	Severity string `yaml:"severity"`
+	Severity                 string `yaml:"severity"`
+	SystemNotificationRuleId string `yaml:"systemNotificationRuleId,omitempty"`
 }
 
 type Rule struct {
@@ -41,15 +63,17 @@ type Rule struct {
 }
 
 type RulerConfigGroup struct {
-	Name  string `yaml:"name"`
-	Rules []Rule `yaml:"rules"`
+	Name     string `yaml:"name"`
+	Interval string `yaml:"interval,omitempty"`
+	Limit    int    `yaml:"limit,omitempty"`
+	Rules    []Rule `yaml:"rules"`
 }
 
 type RulerConfig struct {
 	Groups []RulerConfigGroup `yaml:"groups"`
 }
 
-func processSystemNotificationRule() error {
+func processSystemNotificationRule(ctx context.Context) error {
 	rules, err := systemNotificationRuleAccessor.GetIncompletedRules()
 	if err != nil {
 		return err
@@ -57,7 +81,7 @@ func processSystemNotificationRule() error {
 	if len(rules) == 0 {
 		return nil
 	}
-	log.Info(context.TODO(), "[processSystemNotificationRule] incompleted rules : ", len(rules))
+	log.Info(ctx, "[processSystemNotificationRule] incompleted rules : ", len(rules))
 
 	incompletedOrganizations := []string{}
 
@@ -77,40 +101,120 @@ func processSystemNotificationRule() error {
 	for _, organizationId := range incompletedOrganizations {
 		systemNotificationRules, err := systemNotificationRuleAccessor.GetRules(organizationId)
 		if err != nil {
-			log.Error(context.TODO(), err)
+			log.Error(ctx, err)
 			continue
 		}
 
-		if len(rules) == 0 {
+		// the organization may have no rule left, when its last rule has been deleted
+		organization, err := organizationAccessor.Get(organizationId)
+		if err != nil {
+			log.Error(ctx, err)
 			continue
 		}
-		primaryClusterId := rules[0].Organization.PrimaryClusterId
+		primaryClusterId := organization.PrimaryClusterId
 		if primaryClusterId == "" {
-			log.Error(context.TODO(), fmt.Sprintf("Invalid primary cluster for organization %s", organizationId))
+			log.Error(ctx, fmt.Sprintf("Invalid primary cluster for organization %s", organizationId))
 			continue
 		}
 
-		log.Infof(context.TODO(), "imcompletedOrganizationId[%s] primaryClusterId[%s] rules[%d]", organizationId, primaryClusterId, len(rules))
+		log.Infof(ctx, "imcompletedOrganizationId[%s] primaryClusterId[%s] rules[%d]", organizationId, primaryClusterId, len(systemNotificationRules))
+
+		config, results := buildRulerConfig(systemNotificationRules)
+		for _, result := range results {
+			if result.err != nil {
+				log.Error(ctx, fmt.Sprintf("Excluded invalid rule %s [%s]. err : ", result.rule.Name, result.rule.ID), result.err)
+			}
+		}
 
-		config := RulerConfig{}
-		config.Groups = make([]RulerConfigGroup, 1)
-		config.Groups[0] = RulerConfigGroup{
-			Name: "tks",
+		hash, changed, err := applyRules(ctx, organizationId, primaryClusterId, config)
+		if err != nil {
+			log.Error(ctx, fmt.Sprintf("Failed to apply rules. organizationId[%s] primaryClusterId[%s]", organizationId, primaryClusterId))
+		} else if err := rulerReloadAccessor.SetApplied(organizationId, hash); err != nil {
+			log.Error(ctx, fmt.Sprintf("Failed to record the applied rules. organizationId[%s] err : ", organizationId), err)
 		}
-		config.Groups[0].Rules = make([]Rule, 0)
-		for _, systemNotificationRule := range systemNotificationRules {
-			rule := makeRuleForConfigMap(systemNotificationRule)
-			config.Groups[0].Rules = append(config.Groups[0].Rules, rule)
+		if err = recordRuleResults(organizationId, results, changed, err); err != nil {
+			log.Error(ctx, fmt.Sprintf("Failed to record the results of rules. organizationId[%s] err : ", organizationId), err)
 		}
+	}
+
+	return nil
+}
 
-		err = applyRules(organizationId, primaryClusterId, config)
+// ruleResult is the outcome of generating the rules of a notification rule.
+type ruleResult struct {
+	rule systemNotification.SystemNotificationRule
+	// hash of the generated rules
+	hash string
+	// err is why the rule is invalid
+	err error
+}
+
+// buildRulerConfig makes a group of the rules for each template, so that a slow query delays only the alerts of its template.
+// An invalid rule would break loading all the rules of the organization, so it is excluded and its result has the reason.
+func buildRulerConfig(systemNotificationRules []systemNotification.SystemNotificationRule) (config RulerConfig, results []ruleResult) {
+	// render the rules in the same order every time, so that unchanged rules make the same content
+	sort.SliceStable(systemNotificationRules, func(i, j int) bool {
+		return systemNotificationRules[i].ID.String() < systemNotificationRules[j].ID.String()
+	})
+
+	groups := make(map[string]*RulerConfigGroup)
+	for _, systemNotificationRule := range systemNotificationRules {
+		rules, err := makeRulesForConfigMap(systemNotificationRule)
+		for i := 0; err == nil && i < len(rules); i++ {
+			err = validateRule(rules[i])
+		}
+		if err != nil {
+			results = append(results, ruleResult{rule: systemNotificationRule, err: err})
+			continue
+		}
+		b, err := yaml.Marshal(rules)
 		if err != nil {
-			log.Error(context.TODO(), fmt.Sprintf("Failed to apply rules. organizationId[%s] primaryClusterId[%s]", organizationId, primaryClusterId))
+			results = append(results, ruleResult{rule: systemNotificationRule, err: err})
 			continue
 		}
+		hash := rulesHash(string(b))
+		for i := range rules {
+			rules[i].Annotations.ContentHash = hash
+		}
+		results = append(results, ruleResult{rule: systemNotificationRule, hash: hash})
+
+		templateId := systemNotificationRule.SystemNotificationTemplateId
+		templateName := systemNotificationRule.SystemNotificationTemplate.Name
+		name := ruleGroupName(templateId)
+		group, ok := groups[name]
+		if !ok {
+			group = &RulerConfigGroup{
+				Name:     name,
+				Interval: ruleGroups.interval(templateId, templateName),
+				Limit:    ruleGroups.limit(templateId, templateName),
+				Rules:    make([]Rule, 0),
+			}
+			groups[name] = group
+		}
+		group.Rules = append(group.Rules, rules...)
 	}
 
-	return nil
+	for _, group := range groups {
+		config.Groups = append(config.Groups, *group)
+	}
+	sort.Slice(config.Groups, func(i, j int) bool {
+		return config.Groups[i].Name < config.Groups[j].Name
+	})
+
+	// an empty group replaces the rules which have been written before
+	if len(config.Groups) == 0 {
+		config.Groups = []RulerConfigGroup{{Name: TKS_GROUP_NAME, Rules: make([]Rule, 0)}}
+	}
+	return config, results
+}
+
+// ruleGroupName returns the group name of a template. It depends only on the template id,
+// so that the group keeps its name and the state of its alerts over reloads and renames of the template.
+func ruleGroupName(templateId string) string {
+	if templateId == "" {
+		return TKS_GROUP_NAME
+	}
+	return TKS_GROUP_NAME + "-" + templateId
 }
 
 func replaceMetricParameter(l []systemNotification.SystemNotificationMetricParameter, s string) (out string) {
@@ -129,20 +233,16 @@ func modelToYaml(in any) string {
 }
 */
 
-func makeRuleForConfigMap(systemNotificationRule systemNotification.SystemNotificationRule) (out Rule) {
-	var parameters []domain.SystemNotificationParameter
-	err := json.Unmarshal(systemNotificationRule.SystemNotificationCondition.Parameter, &parameters)
-	if err != nil {
-		log.Error(context.TODO(), err)
-	}
-
-	// expr
-	expr := systemNotificationRule.SystemNotificationTemplate.MetricQuery
-	if len(parameters) == 1 {
-		expr = fmt.Sprintf("%s %s %s", expr, parameters[0].Operator, parameters[0].Value)
-	} else {
-		log.Error(context.TODO(), "Not support multiple parameters")
+// makeRulesForConfigMap returns a rule for each condition in order.
+// The rules share the alert name and differ in severity and threshold.
+func makeRulesForConfigMap(systemNotificationRule systemNotification.SystemNotificationRule) (out []Rule, err error) {
+	conditions := systemNotificationRule.SystemNotificationConditions
+	if len(conditions) == 0 {
+		return nil, fmt.Errorf("no condition")
 	}
+	sort.SliceStable(conditions, func(i, j int) bool {
+		return conditions[i].Order < conditions[j].Order
+	})
 
 	// metric paramters
 	discriminative := ""
@@ -154,97 +254,241 @@ func makeRuleForConfigMap(systemNotificationRule systemNotification.SystemNotifi
 		}
 	}
 
-	out = Rule{
-		Alert: systemNotificationRule.Name,
-		Expr:  expr,
-		For:   systemNotificationRule.SystemNotificationCondition.Duration,
-		Annotations: RuleAnnotation{
-			CheckPoint:               replaceMetricParameter(systemNotificationRule.SystemNotificationTemplate.MetricParameters, systemNotificationRule.MessageActionProposal),
-			Description:              replaceMetricParameter(systemNotificationRule.SystemNotificationTemplate.MetricParameters, systemNotificationRule.MessageContent),
-			Message:                  replaceMetricParameter(systemNotificationRule.SystemNotificationTemplate.MetricParameters, systemNotificationRule.MessageTitle),
-			Discriminative:           discriminative,
-			AlertType:                systemNotificationRule.NotificationType,
-			SystemNotificationRuleId: systemNotificationRule.ID.String(),
-		},
-		Labels: RuleLabels{
-			Severity: systemNotificationRule.SystemNotificationCondition.Severity,
-		},
-	}
+	severities := make(map[string]bool)
+	for _, condition := range conditions {
+		if severities[condition.Severity] {
+			return nil, fmt.Errorf("duplicated severity [%s] in conditions", condition.Severity)
+		}
+		severities[condition.Severity] = true
+
+		// expr
+		expr, err := compileCondition(systemNotificationRule.SystemNotificationTemplate.MetricQuery, condition.Parameter)
+		if err != nil {
+			return nil, fmt.Errorf("condition %d : %s", condition.Order, err)
+		}
+
+		rule := Rule{
+			Alert: systemNotificationRule.Name,
+			Expr:  expr,
+			For:   condition.Duration,
+			Annotations: RuleAnnotation{
+				CheckPoint:               replaceMetricParameter(systemNotificationRule.SystemNotificationTemplate.MetricParameters, systemNotificationRule.MessageActionProposal),
+				Description:              replaceMetricParameter(systemNotificationRule.SystemNotificationTemplate.MetricParameters, systemNotificationRule.MessageContent),
+				Message:                  replaceMetricParameter(systemNotificationRule.SystemNotificationTemplate.MetricParameters, systemNotificationRule.MessageTitle),
+				Discriminative:           discriminative,
+				AlertType:                systemNotificationRule.NotificationType,
+				SystemNotificationRuleId: systemNotificationRule.ID.String(),
+			},
+			Labels: RuleLabels{
+				Severity:                 condition.Severity,
+				SystemNotificationRuleId: systemNotificationRule.ID.String(),
+			},
+		}
 
-	if systemNotificationRule.NotificationType == "POLICY_NOTIFICATION" {
-		out.Annotations.PolicyName = "{{$labels.name}}"
-		out.Annotations.PolicyTemplateName = "{{$labels.kind}}"
+		if systemNotificationRule.NotificationType == "POLICY_NOTIFICATION" {
+			rule.Annotations.PolicyName = "{{$labels.name}}"
+			rule.Annotations.PolicyTemplateName = "{{$labels.kind}}"
+		}
+		out = append(out, rule)
 	}
 
-	return out
+	return out, nil
 }
 
-func applyRules(organizationId string, primaryClusterId string, rc RulerConfig) (err error) {
-	clientset, err := kubernetes.GetClientFromClusterId(context.TODO(), primaryClusterId)
+// getRulerConfigMap returns the ConfigMap of thanos-ruler in the primary cluster and the layout where it is.
+func getRulerConfigMap(ctx context.Context, primaryClusterId string) (*k8s.Clientset, *corev1.ConfigMap, rulerLayout, error) {
+	layout, err := getRulerLayout(ctx, primaryClusterId)
 	if err != nil {
-		log.Error(context.TODO(), err)
-		return err
+		return nil, nil, layout, err
 	}
 
-	cm, err := clientset.CoreV1().ConfigMaps("lma").Get(context.TODO(), "thanos-ruler-configmap", metav1.GetOptions{})
+	clientset, err := kubernetes.GetClientFromClusterId(ctx, primaryClusterId)
 	if err != nil {
-		log.Error(context.TODO(), err)
-		return err
+		return nil, nil, layout, err
 	}
 
-	var rulerConfig RulerConfig
-	err = yaml.Unmarshal([]byte(cm.Data[RULER_FILE_NAME]), &rulerConfig)
+	cm, err := clientset.CoreV1().ConfigMaps(layout.Namespace).Get(ctx, layout.ConfigMap, metav1.GetOptions{})
 	if err != nil {
-		log.Error(context.TODO(), err)
-		return err
+		return nil, nil, layout, err
+	}
+	return clientset, cm, layout, nil
+}
+
+// renderRules returns the content of ruler-user.yml whose groups are replaced with rc.
+func renderRules(current string, rc RulerConfig) (string, error) {
+	// keep everything which tks-batch does not own as it is, including unknown fields
+	var rulerConfig yaml.MapSlice
+	err := yaml.Unmarshal([]byte(current), &rulerConfig)
+	if err != nil {
+		return "", err
 	}
 
 	if rc.Groups == nil || len(rc.Groups) == 0 {
-		return fmt.Errorf("empty rc.Groups")
+		return "", fmt.Errorf("empty rc.Groups")
 	}
 
-	rulerConfig.Groups = rc.Groups
+	groupsIndex := -1
+	var currentGroups []interface{}
+	for i, item := range rulerConfig {
+		if item.Key == "groups" {
+			groupsIndex = i
+			if item.Value != nil {
+				groups, ok := item.Value.([]interface{})
+				if !ok {
+					return "", fmt.Errorf("invalid groups in the rule file")
+				}
+				currentGroups = groups
+			}
+			break
+		}
+	}
 
-	/*
-		outYaml, err := yaml.Marshal(rulerConfig)
-		if err != nil {
-			log.Error(context.TODO(), err)
+	// the groups of tks-batch take the place of the first group which tks-batch owned
+	groups := make([]interface{}, 0, len(currentGroups)+len(rc.Groups))
+	inserted := false
+	for _, group := range currentGroups {
+		if !isTksGroup(groupName(group)) {
+			groups = append(groups, group)
 			continue
 		}
-		log.Info(context.TODO(), string(outYaml))
-	*/
+		if !inserted {
+			for _, g := range rc.Groups {
+				groups = append(groups, g)
+			}
+			inserted = true
+		}
+	}
+	if !inserted {
+		for _, g := range rc.Groups {
+			groups = append(groups, g)
+		}
+	}
+
+	if groupsIndex < 0 {
+		rulerConfig = append(rulerConfig, yaml.MapItem{Key: "groups", Value: groups})
+	} else {
+		rulerConfig[groupsIndex].Value = groups
+	}
 
 	b, err := yaml.Marshal(rulerConfig)
 	if err != nil {
-		log.Error(context.TODO(), err)
-		return err
+		return "", err
 	}
-	cm.Data[RULER_FILE_NAME] = string(b)
+	return string(b), nil
+}
 
-	_, err = clientset.CoreV1().ConfigMaps("lma").Update(context.TODO(), cm, metav1.UpdateOptions{})
-	if err != nil {
-		log.Error(context.TODO(), err)
-		return err
+// isTksGroup tells whether the group is generated by tks-batch.
+// Other groups are added by operators and must not be touched.
+func isTksGroup(name string) bool {
+	return name == TKS_GROUP_NAME || strings.HasPrefix(name, TKS_GROUP_NAME+"-")
+}
+
+func groupName(group interface{}) string {
+	g, ok := group.(yaml.MapSlice)
+	if !ok {
+		return ""
+	}
+	for _, item := range g {
+		if item.Key == "name" {
+			name, _ := item.Value.(string)
+			return name
+		}
 	}
+	return ""
+}
+
+// rulesHash returns the content hash of a rendered ruler-user.yml.
+func rulesHash(rendered string) string {
+	sum := sha256.Sum256([]byte(rendered))
+	return hex.EncodeToString(sum[:])
+}
 
-	// restart thanos-ruler
-	// thanos-ruler reload 방식으로 변경했으나, 혹시 몰라 일단 코드는 주석처리해둠
-	/*
-		deletePolicy := metav1.DeletePropagationForeground
-		err = clientset.CoreV1().Pods("lma").Delete(context.TODO(), "thanos-ruler-0", metav1.DeleteOptions{
-			PropagationPolicy: &deletePolicy,
-		})
+// applyRules writes the rules to the ConfigMap and reports the hash of the content and whether it has changed.
+func applyRules(ctx context.Context, organizationId string, primaryClusterId string, rc RulerConfig) (hash string, changed bool, err error) {
+	// the ConfigMap is updated with the resourceVersion of the Get, so a concurrent update fails with a conflict
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		clientset, cm, layout, err := getRulerConfigMap(ctx, primaryClusterId)
 		if err != nil {
-			log.Error(context.TODO(), err)
 			return err
 		}
-	*/
 
-	// update status
-	err = systemNotificationRuleAccessor.UpdateSystemNotificationRuleStatus(organizationId, domain.SystemNotificationRuleStatus_APPLIED)
-	if err != nil {
-		log.Error(context.TODO(), err)
+		rendered, err := renderRules(cm.Data[layout.RuleFile], rc)
+		if err != nil {
+			return err
+		}
+		hash = rulesHash(rendered)
+		if cm.Data[layout.RuleFile] == rendered && cm.Annotations[RULES_HASH_ANNOTATION] == hash {
+			changed = false
+			return nil
+		}
+
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		cm.Data[layout.RuleFile] = rendered
+		if cm.Annotations == nil {
+			cm.Annotations = make(map[string]string)
+		}
+		cm.Annotations[RULES_HASH_ANNOTATION] = hash
+
+		_, err = clientset.CoreV1().ConfigMaps(layout.Namespace).Update(ctx, cm, metav1.UpdateOptions{})
+		changed = err == nil
 		return err
+	})
+	if err != nil {
+		metrics.ConfigMapApplies.WithLabelValues(metrics.ResultError).Inc()
+		log.Error(ctx, err)
+		return "", false, err
 	}
-	return nil
+
+	if !changed {
+		metrics.ConfigMapApplies.WithLabelValues(metrics.ResultSkipped).Inc()
+		log.Info(ctx, fmt.Sprintf("rules of organization %s are not changed. skipped updating the ConfigMap", organizationId))
+		return hash, false, nil
+	}
+	metrics.ConfigMapApplies.WithLabelValues(metrics.ResultSuccess).Inc()
+	return hash, true, nil
+}
+
+// recordRuleResults updates the status of every rule of the organization by the result of applyRules.
+func recordRuleResults(organizationId string, results []ruleResult, changed bool, applyErr error) error {
+	now := time.Now()
+	statuses := make([]ruleStatus.RuleStatus, 0, len(results))
+	applied := []uuid.UUID{}
+	for _, result := range results {
+		status := ruleStatus.RuleStatus{
+			RuleId:      result.rule.ID.String(),
+			ContentHash: result.hash,
+			UpdatedAt:   now,
+		}
+		switch {
+		case result.err != nil:
+			status.Status = ruleStatus.StatusInvalid
+			status.Reason = result.err.Error()
+			if err := systemNotificationRuleAccessor.UpdateRuleStatus(result.rule.ID, domain.SystemNotificationRuleStatus_ERROR); err != nil {
+				return err
+			}
+		case applyErr != nil:
+			// the rule stays PENDING to be applied again
+			status.Status = ruleStatus.StatusSkipped
+			status.Reason = applyErr.Error()
+		default:
+			status.Status = ruleStatus.StatusApplied
+			if changed {
+				status.AppliedAt = &now
+			}
+			applied = append(applied, result.rule.ID)
+		}
+		statuses = append(statuses, status)
+	}
+
+	if applyErr == nil {
+		if err := systemNotificationRuleAccessor.UpdateRulesStatus(applied, domain.SystemNotificationRuleStatus_APPLIED); err != nil {
+			return err
+		}
+		if err := systemNotificationRuleAccessor.MarkDeletedRulesSynced(organizationId); err != nil {
+			return err
+		}
+	}
+	return ruleStatusAccessor.Save(organizationId, statuses)
 }
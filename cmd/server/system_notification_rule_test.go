@@ -0,0 +1,377 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gofrs/uuid"
+	systemNotification "github.com/openinfradev/tks-batch/internal/system-notification-rule"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+)
+
+const (
+	testTemplateCpu    = "0b9b6a2e-8a3b-4d2f-9a55-2f0c8f1b7c01"
+	testTemplateMemory = "5f8e1c4d-3b2a-4e6f-8d7c-9a0b1c2d3e02"
+)
+
+func newTestRule(id string, name string, templateId string, templateName string, conditions ...systemNotification.SystemNotificationCondition) systemNotification.SystemNotificationRule {
+	return systemNotification.SystemNotificationRule{
+		ID:   uuid.Must(uuid.FromString(id)),
+		Name: name,
+		SystemNotificationTemplate: systemNotification.SystemNotificationTemplate{
+			Name:        templateName,
+			MetricQuery: "node_load1",
+		},
+		SystemNotificationTemplateId: templateId,
+		SystemNotificationConditions: conditions,
+		MessageTitle:                 "load of {{$labels.instance}}",
+	}
+}
+
+func newTestCondition(order int, severity string, operator string, value string) systemNotification.SystemNotificationCondition {
+	return systemNotification.SystemNotificationCondition{
+		Order:     order,
+		Severity:  severity,
+		Duration:  "5m",
+		Parameter: datatypes.JSON(`[{"order":0,"operator":"` + operator + `","value":"` + value + `"}]`),
+	}
+}
+
+func TestRuleGroupName(t *testing.T) {
+	testCases := []struct {
+		name       string
+		templateId string
+		want       string
+	}{
+		{"template id", testTemplateCpu, "tks-" + testTemplateCpu},
+		{"no template", "", TKS_GROUP_NAME},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := ruleGroupName(tc.templateId)
+			require.Equal(t, tc.want, got)
+			require.True(t, isTksGroup(got))
+		})
+	}
+}
+
+func TestBuildRulerConfig(t *testing.T) {
+	var err error
+	ruleGroups, err = parseRuleGroupConfig("1m", 0, testTemplateMemory+"=30s,cpu=2m", "cpu=10")
+	require.NoError(t, err)
+	defer func() { ruleGroups = ruleGroupConfig{} }()
+
+	testCases := []struct {
+		name       string
+		rules      []systemNotification.SystemNotificationRule
+		wantGroups []RulerConfigGroup
+		wantErrs   []string
+	}{
+		{
+			name:       "no rule",
+			wantGroups: []RulerConfigGroup{{Name: TKS_GROUP_NAME, Rules: []Rule{}}},
+		},
+		{
+			name: "a rule for each condition",
+			rules: []systemNotification.SystemNotificationRule{
+				newTestRule("00000000-0000-0000-0000-000000000001", "rule1", testTemplateCpu, "cpu",
+					newTestCondition(1, "critical", ">", "8"),
+					newTestCondition(0, "warning", ">", "4")),
+			},
+			wantGroups: []RulerConfigGroup{{
+				Name:     "tks-" + testTemplateCpu,
+				Interval: "2m",
+				Limit:    10,
+				Rules: []Rule{
+					{Alert: "rule1", Expr: "node_load1 > 4", Labels: RuleLabels{Severity: "warning"}},
+					{Alert: "rule1", Expr: "node_load1 > 8", Labels: RuleLabels{Severity: "critical"}},
+				},
+			}},
+		},
+		{
+			name: "a group for each template sorted by id",
+			rules: []systemNotification.SystemNotificationRule{
+				newTestRule("00000000-0000-0000-0000-000000000003", "rule3", testTemplateMemory, "memory",
+					newTestCondition(0, "warning", "<", "0.1")),
+				newTestRule("00000000-0000-0000-0000-000000000002", "rule2", testTemplateCpu, "cpu",
+					newTestCondition(0, "warning", ">", "4")),
+				newTestRule("00000000-0000-0000-0000-000000000001", "rule1", testTemplateCpu, "cpu",
+					newTestCondition(0, "critical", ">", "8")),
+			},
+			wantGroups: []RulerConfigGroup{
+				{
+					Name:     "tks-" + testTemplateCpu,
+					Interval: "2m",
+					Limit:    10,
+					Rules: []Rule{
+						{Alert: "rule1", Expr: "node_load1 > 8", Labels: RuleLabels{Severity: "critical"}},
+						{Alert: "rule2", Expr: "node_load1 > 4", Labels: RuleLabels{Severity: "warning"}},
+					},
+				},
+				{
+					Name:     "tks-" + testTemplateMemory,
+					Interval: "30s",
+					Rules: []Rule{
+						{Alert: "rule3", Expr: "node_load1 < 0.1", Labels: RuleLabels{Severity: "warning"}},
+					},
+				},
+			},
+		},
+		{
+			name: "templates with non-ascii names do not share a group",
+			rules: []systemNotification.SystemNotificationRule{
+				newTestRule("00000000-0000-0000-0000-000000000001", "rule1", testTemplateCpu, "씨피유",
+					newTestCondition(0, "warning", ">", "4")),
+				newTestRule("00000000-0000-0000-0000-000000000002", "rule2", testTemplateMemory, "메모리",
+					newTestCondition(0, "warning", ">", "4")),
+			},
+			wantGroups: []RulerConfigGroup{
+				{
+					Name:     "tks-" + testTemplateCpu,
+					Interval: "1m",
+					Rules: []Rule{
+						{Alert: "rule1", Expr: "node_load1 > 4", Labels: RuleLabels{Severity: "warning"}},
+					},
+				},
+				{
+					Name:     "tks-" + testTemplateMemory,
+					Interval: "30s",
+					Rules: []Rule{
+						{Alert: "rule2", Expr: "node_load1 > 4", Labels: RuleLabels{Severity: "warning"}},
+					},
+				},
+			},
+		},
+		{
+			name: "invalid rules are excluded",
+			rules: []systemNotification.SystemNotificationRule{
+				newTestRule("00000000-0000-0000-0000-000000000001", "rule1", testTemplateCpu, "cpu",
+					newTestCondition(0, "warning", ">", "4")),
+				newTestRule("00000000-0000-0000-0000-000000000002", "rule2", testTemplateCpu, "cpu"),
+				newTestRule("00000000-0000-0000-0000-000000000003", "rule3", testTemplateCpu, "cpu",
+					newTestCondition(0, "warning", ">", "4"),
+					newTestCondition(1, "warning", ">", "8")),
+				newTestRule("00000000-0000-0000-0000-000000000004", "rule4", testTemplateCpu, "cpu",
+					newTestCondition(0, "warning", "=~", "4")),
+			},
+			wantGroups: []RulerConfigGroup{{
+				Name:     "tks-" + testTemplateCpu,
+				Interval: "2m",
+				Limit:    10,
+				Rules: []Rule{
+					{Alert: "rule1", Expr: "node_load1 > 4", Labels: RuleLabels{Severity: "warning"}},
+				},
+			}},
+			wantErrs: []string{"rule2", "rule3", "rule4"},
+		},
+		{
+			name: "only invalid rules",
+			rules: []systemNotification.SystemNotificationRule{
+				newTestRule("00000000-0000-0000-0000-000000000001", "rule1", testTemplateCpu, "cpu"),
+			},
+			wantGroups: []RulerConfigGroup{{Name: TKS_GROUP_NAME, Rules: []Rule{}}},
+			wantErrs:   []string{"rule1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config, results := buildRulerConfig(tc.rules)
+
+			require.Len(t, config.Groups, len(tc.wantGroups))
+			for i, want := range tc.wantGroups {
+				got := config.Groups[i]
+				require.Equal(t, want.Name, got.Name)
+				require.Equal(t, want.Interval, got.Interval)
+				require.Equal(t, want.Limit, got.Limit)
+				require.Len(t, got.Rules, len(want.Rules))
+				for j, wantRule := range want.Rules {
+					gotRule := got.Rules[j]
+					require.Equal(t, wantRule.Alert, gotRule.Alert)
+					require.Equal(t, wantRule.Expr, gotRule.Expr)
+					require.Equal(t, "5m", gotRule.For)
+					require.Equal(t, wantRule.Labels.Severity, gotRule.Labels.Severity)
+					require.Equal(t, gotRule.Labels.SystemNotificationRuleId, gotRule.Annotations.SystemNotificationRuleId)
+					require.Equal(t, "load of {{$labels.instance}}", gotRule.Annotations.Message)
+				}
+			}
+
+			require.Len(t, results, len(tc.rules))
+			var errs []string
+			hashes := make(map[string]string)
+			for _, result := range results {
+				if result.err != nil {
+					require.Empty(t, result.hash)
+					errs = append(errs, result.rule.Name)
+				} else {
+					require.NotEmpty(t, result.hash)
+					hashes[result.rule.ID.String()] = result.hash
+				}
+			}
+			require.Equal(t, tc.wantErrs, errs)
+
+			// every rule carries the content hash of its notification rule to be verified against
+			for _, group := range config.Groups {
+				for _, rule := range group.Rules {
+					require.Equal(t, hashes[rule.Labels.SystemNotificationRuleId], rule.Annotations.ContentHash)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderRules(t *testing.T) {
+	config := RulerConfig{Groups: []RulerConfigGroup{{
+		Name:     "tks-a",
+		Interval: "1m",
+		Rules: []Rule{{
+			Alert:  "high-load",
+			Expr:   "node_load1 > 4",
+			For:    "5m",
+			Labels: RuleLabels{Severity: "warning", SystemNotificationRuleId: "r1"},
+		}},
+	}}}
+	tksGroup := `- name: tks-a
+  interval: 1m
+  rules:
+  - alert: high-load
+    expr: node_load1 > 4
+    for: 5m
+    labels:
+      severity: warning
+      systemNotificationRuleId: r1
+    annotations:
+      CheckPoint: ""
+      description: ""
+      discriminative: ""
+      message: ""
+      summary: ""
+      alertType: ""
+`
+
+	testCases := []struct {
+		name    string
+		current string
+		config  RulerConfig
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "empty file",
+			current: "",
+			config:  config,
+			want:    "groups:\n" + tksGroup,
+		},
+		{
+			name:    "no groups",
+			current: "groups: []\n",
+			config:  config,
+			want:    "groups:\n" + tksGroup,
+		},
+		{
+			name: "groups of tks-batch are replaced",
+			current: `groups:
+- name: tks
+  rules: []
+- name: tks-old
+  rules:
+  - alert: old
+    expr: up == 0
+`,
+			config: config,
+			want:   "groups:\n" + tksGroup,
+		},
+		{
+			name: "foreign groups and unknown fields are kept in place",
+			current: `namespace: monitoring
+groups:
+- name: operator-first
+  partial_response_strategy: warn
+  rules:
+  - record: job:up:sum
+    expr: sum by (job) (up)
+- name: tks-old
+  rules: []
+- name: operator-last
+  rules:
+  - alert: Down
+    expr: up == 0
+    keep_firing_for: 5m
+`,
+			config: config,
+			want: `namespace: monitoring
+groups:
+- name: operator-first
+  partial_response_strategy: warn
+  rules:
+  - record: job:up:sum
+    expr: sum by (job) (up)
+` + tksGroup + `- name: operator-last
+  rules:
+  - alert: Down
+    expr: up == 0
+    keep_firing_for: 5m
+`,
+		},
+		{
+			name: "appended without a group of tks-batch",
+			current: `groups:
+- name: operator
+  rules: []
+`,
+			config: config,
+			want: `groups:
+- name: operator
+  rules: []
+` + tksGroup,
+		},
+		{
+			name: "a group named like tks is not owned",
+			current: `groups:
+- name: tksfoo
+  rules: []
+`,
+			config: config,
+			want: `groups:
+- name: tksfoo
+  rules: []
+` + tksGroup,
+		},
+		{
+			name:    "empty config",
+			current: "groups: []\n",
+			config:  RulerConfig{},
+			wantErr: true,
+		},
+		{
+			name:    "invalid groups",
+			current: "groups: tks\n",
+			config:  config,
+			wantErr: true,
+		},
+		{
+			name:    "invalid yaml",
+			current: "groups: [\n",
+			config:  config,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := renderRules(tc.current, tc.config)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+
+			// rendering again makes the same content, so that the hash does not change
+			again, err := renderRules(got, tc.config)
+			require.NoError(t, err)
+			require.Equal(t, got, again)
+		})
+	}
+}
@@ -3,110 +3,228 @@ package main
 import (
 	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"strconv"
+	"os"
+	"sort"
+	"time"
 
 	"github.com/openinfradev/tks-api/pkg/kubernetes"
 	"github.com/openinfradev/tks-api/pkg/log"
-	gcache "github.com/patrickmn/go-cache"
+	"github.com/openinfradev/tks-batch/internal/metrics"
+	ruleStatus "github.com/openinfradev/tks-batch/internal/rule-status"
+	rulerReload "github.com/openinfradev/tks-batch/internal/ruler-reload"
+	thanosRuler "github.com/openinfradev/tks-batch/internal/thanos-ruler"
 	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-const LAST_UPDATED_MIN = 2
+// MAX_RELOAD_BACKOFF caps the wait before retrying a failed reload.
+const MAX_RELOAD_BACKOFF = 10 * time.Minute
 
-func processReloadThanosRules() error {
-	organizationIds, err := systemNotificationRuleAccessor.GetRecentlyUpdatedOrganizations(LAST_UPDATED_MIN)
+// RULE_CONTENT_HASH_ANNOTATION of a generated rule has the content hash of its notification rule in rule_statuses.
+const RULE_CONTENT_HASH_ANNOTATION = "contentHash"
+
+// processReloadThanosRules reloads thanos-ruler of the organizations whose applied rules have not been loaded yet.
+// The bookkeeping is in DB, so the changes applied before a restart are reloaded after it.
+func processReloadThanosRules(ctx context.Context) error {
+	pendings, err := rulerReloadAccessor.GetPending()
 	if err != nil {
 		return err
 	}
-	if len(organizationIds) == 0 {
+	if len(pendings) == 0 {
 		return nil
 	}
-	log.Info(context.TODO(), "[processReloadThanosRules] new updated organizationIds : ", organizationIds)
+	log.Info(ctx, "[processReloadThanosRules] pending organizations : ", len(pendings))
 
-	for _, organizationId := range organizationIds {
-		organization, err := organizationAccessor.Get(organizationId)
+	for _, pending := range pendings {
+		organizationId := pending.OrganizationId
+		strategy := reloads.strategy(organizationId)
+		done, err := reloadOrganization(ctx, pending, strategy)
 		if err != nil {
-			log.Error(context.TODO(), err)
+			log.Error(ctx, fmt.Sprintf("Failed to reload thanos-ruler of organization %s by %s (attempt %d). err : ", organizationId, strategy, pending.Attempts+1), err)
+			backoff := time.Second * time.Duration(viper.GetInt("thanos-ruler-reload-backoff-sec")) << min(pending.Attempts, 10)
+			if err = rulerReloadAccessor.SetFailed(organizationId, pending.AppliedHash, err, time.Now().Add(min(backoff, MAX_RELOAD_BACKOFF))); err != nil {
+				log.Error(ctx, err)
+			}
 			continue
 		}
-
-		url, err := GetThanosRulerUrl(organization.PrimaryClusterId)
-		if err != nil {
-			log.Error(context.TODO(), err)
+		if !done {
 			continue
 		}
-
-		if err = Reload(url); err != nil {
-			log.Error(context.TODO(), err)
-			continue
+		if err = rulerReloadAccessor.SetReloaded(organizationId, pending.AppliedHash); err != nil {
+			log.Error(ctx, err)
 		}
 	}
 
 	return nil
 }
 
-func GetThanosRulerUrl(primaryClusterId string) (url string, err error) {
-	const prefix = "CACHE_KEY_THANOS_RULER_URL"
-	value, found := cache.Get(prefix + primaryClusterId)
-	if found {
-		log.Info(context.TODO(), "Cache HIT [CACHE_KEY_THANOS_RULER_URL] ", value)
-		return value.(string), nil
-	}
-
-	clientset_admin, err := kubernetes.GetClientAdminCluster(context.TODO())
+func reloadOrganization(ctx context.Context, pending rulerReload.RulerReload, strategy string) (done bool, err error) {
+	organization, err := organizationAccessor.Get(pending.OrganizationId)
 	if err != nil {
-		return url, errors.Wrap(err, "Failed to get client set for user cluster")
+		return false, err
 	}
+	if organization.PrimaryClusterId == "" {
+		return false, fmt.Errorf("invalid primary cluster for organization %s", pending.OrganizationId)
+	}
+	return reloadThanosRuler(ctx, pending, organization.PrimaryClusterId, strategy)
+}
 
-	secrets, err := clientset_admin.CoreV1().Secrets(primaryClusterId).Get(context.TODO(), "tks-endpoint-secret", metav1.GetOptions{})
-	if err != nil {
-		log.Info(context.TODO(), "cannot found tks-endpoint-secret. so use LoadBalancer...")
+// Keys of the secret which has the TLS options and credentials of thanos-ruler in the namespace of a cluster
+const (
+	RULER_AUTH_CA                   = "ca.crt"
+	RULER_AUTH_CERT                 = "tls.crt"
+	RULER_AUTH_KEY                  = "tls.key"
+	RULER_AUTH_TOKEN                = "token"
+	RULER_AUTH_USERNAME             = "username"
+	RULER_AUTH_PASSWORD             = "password"
+	RULER_AUTH_INSECURE_SKIP_VERIFY = "insecure-skip-verify"
+)
 
-		clientset_user, err := kubernetes.GetClientFromClusterId(context.TODO(), primaryClusterId)
-		if err != nil {
-			return url, errors.Wrap(err, "Failed to get client set for user cluster")
+// newThanosRulerClient makes a client for the endpoint with the global options and the auth secret of the cluster.
+func newThanosRulerClient(ctx context.Context, primaryClusterId string, endpoint rulerEndpoint) (*thanosRuler.Client, error) {
+	opts := thanosRuler.Options{
+		HttpClient:  endpoint.Client,
+		Timeout:     time.Second * time.Duration(viper.GetInt("thanos-ruler-timeout-sec")),
+		MaxAttempts: viper.GetInt("thanos-ruler-retry-max"),
+		Backoff:     time.Second * time.Duration(viper.GetInt("thanos-ruler-retry-backoff-sec")),
+	}
+
+	var err error
+	if path := viper.GetString("thanos-ruler-ca-file"); path != "" {
+		if opts.CA, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if path := viper.GetString("thanos-ruler-cert-file"); path != "" {
+		if opts.Cert, err = os.ReadFile(path); err != nil {
+			return nil, err
 		}
+		if opts.Key, err = os.ReadFile(viper.GetString("thanos-ruler-key-file")); err != nil {
+			return nil, err
+		}
+	}
+
+	// the api-server proxy is authenticated with the kubeconfig of the cluster
+	if endpoint.Client != nil {
+		return thanosRuler.NewWithOptions(endpoint.Url, opts)
+	}
 
-		service, err := clientset_user.CoreV1().Services("lma").Get(context.TODO(), "thanos-ruler", metav1.GetOptions{})
+	if name := viper.GetString("thanos-ruler-auth-secret"); name != "" {
+		clientset_admin, err := kubernetes.GetClientAdminCluster(ctx)
 		if err != nil {
-			return url, errors.Wrap(err, "Failed to get services.")
+			return nil, errors.Wrap(err, "Failed to get client set for admin cluster")
 		}
-
-		// LoadBalaner 일경우, aws address 형태의 경우만 가정한다.
-		if service.Spec.Type != "LoadBalancer" {
-			return url, fmt.Errorf("Service type is not LoadBalancer. [%s] ", service.Spec.Type)
+		secret, err := clientset_admin.CoreV1().Secrets(primaryClusterId).Get(ctx, name, metav1.GetOptions{})
+		if err != nil && !k8sErrors.IsNotFound(err) {
+			return nil, err
 		}
-
-		lbs := service.Status.LoadBalancer.Ingress
-		ports := service.Spec.Ports
-		if len(lbs) > 0 && len(ports) > 0 {
-			url = ports[0].TargetPort.StrVal + "://" + lbs[0].Hostname + ":" + strconv.Itoa(int(ports[0].Port))
+		if err == nil {
+			if ca := secret.Data[RULER_AUTH_CA]; len(ca) > 0 {
+				opts.CA = append(append(opts.CA, '\n'), ca...)
+			}
+			if cert := secret.Data[RULER_AUTH_CERT]; len(cert) > 0 {
+				opts.Cert, opts.Key = cert, secret.Data[RULER_AUTH_KEY]
+			}
+			opts.InsecureSkipVerify = string(secret.Data[RULER_AUTH_INSECURE_SKIP_VERIFY]) == "true"
+			opts.BearerToken = string(secret.Data[RULER_AUTH_TOKEN])
+			opts.Username = string(secret.Data[RULER_AUTH_USERNAME])
+			opts.Password = string(secret.Data[RULER_AUTH_PASSWORD])
 		}
-	} else {
-		url = "http://" + string(secrets.Data["thanos-ruler"])
 	}
-
-	cache.Set(prefix+primaryClusterId, url, gcache.DefaultExpiration)
-	return url, nil
+	return thanosRuler.NewWithOptions(endpoint.Url, opts)
 }
 
-func Reload(thanosRulerUrl string) (err error) {
-	reqUrl := thanosRulerUrl + "/-/reload"
+// verifyRules checks that thanos-ruler has loaded the applied version of every rule of the organization and no deleted rule,
+// and records whether each rule is loaded and evaluated without an error.
+// Until waitUntil, it records nothing and reports false while thanos-ruler has not loaded the applied rules.
+func verifyRules(ctx context.Context, organizationId string, client *thanosRuler.Client, waitUntil time.Time) (loaded bool, err error) {
+	statuses, err := ruleStatusAccessor.GetByOrganization(organizationId)
+	if err != nil {
+		return false, err
+	}
+	statuses = statusesToVerify(statuses)
 
-	log.Info(context.TODO(), "url : ", reqUrl)
-	resp, err := http.Post(reqUrl, "text/plain", nil)
+	groups, err := client.Rules(ctx)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	defer resp.Body.Close()
+	loadedRules, reasons, deleted := compareLoadedRules(statuses, groups)
+	loaded = len(reasons) == 0 && len(deleted) == 0
+	if !loaded && time.Now().Before(waitUntil) {
+		return false, nil
+	}
 
-	_, err = io.ReadAll(resp.Body)
-	if err != nil {
-		return err
+	for _, status := range statuses {
+		newStatus, reason := ruleStatus.StatusApplied, ""
+		if reasons[status.RuleId] != "" {
+			newStatus, reason = ruleStatus.StatusNotLoaded, reasons[status.RuleId]
+		}
+		for _, rule := range loadedRules[status.RuleId] {
+			if newStatus == ruleStatus.StatusApplied && rule.Health == thanosRuler.HealthErr {
+				newStatus, reason = ruleStatus.StatusNotLoaded, fmt.Sprintf("failed to evaluate in thanos-ruler. %s", rule.LastError)
+			}
+		}
+
+		if newStatus == ruleStatus.StatusApplied {
+			metrics.ThanosRuleVerifications.WithLabelValues(metrics.ResultSuccess).Inc()
+		} else {
+			metrics.ThanosRuleVerifications.WithLabelValues(metrics.ResultError).Inc()
+			log.Error(ctx, fmt.Sprintf("rule %s of organization %s is not loaded. %s", status.RuleId, organizationId, reason))
+		}
+		if err := ruleStatusAccessor.UpdateVerification(status.RuleId, newStatus, reason); err != nil {
+			return false, err
+		}
 	}
-	return nil
+	for _, id := range deleted {
+		metrics.ThanosRuleVerifications.WithLabelValues(metrics.ResultError).Inc()
+		log.Error(ctx, fmt.Sprintf("rule %s of organization %s has been deleted or is invalid, but is still loaded in thanos-ruler", id, organizationId))
+	}
+	return loaded, nil
+}
+
+// compareLoadedRules returns the loaded rules of tks-batch by systemNotificationRuleId label,
+// why the applied version of a rule is not loaded, and the rules which are loaded though they have not been applied.
+// The content hash of a rule covers its query, severity and everything else.
+func compareLoadedRules(statuses []ruleStatus.RuleStatus, groups []thanosRuler.Group) (loadedRules map[string][]thanosRuler.Rule, reasons map[string]string, deleted []string) {
+	loadedRules = make(map[string][]thanosRuler.Rule)
+	for _, group := range groups {
+		if !isTksGroup(group.Name) {
+			continue
+		}
+		for _, rule := range group.Rules {
+			if id := rule.Labels["systemNotificationRuleId"]; id != "" {
+				loadedRules[id] = append(loadedRules[id], rule)
+			}
+		}
+	}
+
+	reasons = make(map[string]string)
+	applied := make(map[string]bool)
+	for _, status := range statuses {
+		applied[status.RuleId] = true
+		rules, ok := loadedRules[status.RuleId]
+		if !ok {
+			reasons[status.RuleId] = "not found in thanos-ruler"
+			continue
+		}
+		for _, rule := range rules {
+			if rule.Annotations[RULE_CONTENT_HASH_ANNOTATION] != status.ContentHash {
+				reasons[status.RuleId] = "an outdated version is loaded in thanos-ruler"
+				break
+			}
+		}
+	}
+
+	// the rules which have been deleted or have become invalid since
+	deleted = []string{}
+	for id := range loadedRules {
+		if !applied[id] {
+			deleted = append(deleted, id)
+		}
+	}
+	sort.Strings(deleted)
+	return loadedRules, reasons, deleted
 }
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/openinfradev/tks-api/pkg/kubernetes"
+	"github.com/openinfradev/tks-api/pkg/log"
+	gcache "github.com/patrickmn/go-cache"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Strategies to discover the url of thanos-ruler
+const (
+	DiscoveryOverride       = "override"
+	DiscoveryEndpointSecret = "endpoint-secret"
+	DiscoveryIngress        = "ingress"
+	DiscoveryRoute          = "route"
+	DiscoveryLoadBalancer   = "loadbalancer"
+	DiscoveryNodePort       = "nodeport"
+	DiscoveryProxy          = "proxy"
+)
+
+const DEFAULT_THANOS_RULER_DISCOVERY = "override,endpoint-secret,ingress,route,loadbalancer,nodeport,proxy"
+
+var routeResource = schema.GroupVersionResource{Group: "route.openshift.io", Version: "v1", Resource: "routes"}
+
+// rulerEndpoint is where thanos-ruler of an organization is served.
+type rulerEndpoint struct {
+	Url      string
+	Strategy string
+	// Client is set when the url needs the credential of the cluster, such as the api-server proxy.
+	Client *http.Client
+}
+
+// errNotDiscovered makes the discovery go on with the next strategy.
+var errNotDiscovered = errors.New("not discovered")
+
+// rulerDiscovery has the clients of the primary cluster shared by the strategies.
+type rulerDiscovery struct {
+	organizationId   string
+	primaryClusterId string
+	layout           rulerLayout
+	config           *rest.Config
+	clientset        *k8s.Clientset
+	service          *corev1.Service
+}
+
+var rulerDiscoveries = map[string]func(ctx context.Context, d *rulerDiscovery) (rulerEndpoint, error){
+	DiscoveryOverride:       discoverByOverride,
+	DiscoveryEndpointSecret: discoverByEndpointSecret,
+	DiscoveryIngress:        discoverByIngress,
+	DiscoveryRoute:          discoverByRoute,
+	DiscoveryLoadBalancer:   discoverByLoadBalancer,
+	DiscoveryNodePort:       discoverByNodePort,
+	DiscoveryProxy:          discoverByProxy,
+}
+
+// getThanosRulerEndpoint tries the strategies in thanos-ruler-discovery in order and caches the first endpoint found.
+func getThanosRulerEndpoint(ctx context.Context, organizationId string, primaryClusterId string) (rulerEndpoint, error) {
+	const prefix = "CACHE_KEY_THANOS_RULER_ENDPOINT"
+	key := prefix + organizationId + "/" + primaryClusterId
+	if value, found := cache.Get(key); found {
+		endpoint := value.(rulerEndpoint)
+		log.Info(ctx, fmt.Sprintf("Cache HIT [%s] %s (%s)", prefix, endpoint.Url, endpoint.Strategy))
+		return endpoint, nil
+	}
+
+	layout, err := getRulerLayout(ctx, primaryClusterId)
+	if err != nil {
+		return rulerEndpoint{}, err
+	}
+	d := &rulerDiscovery{organizationId: organizationId, primaryClusterId: primaryClusterId, layout: layout}
+	for _, strategy := range strings.Split(viper.GetString("thanos-ruler-discovery"), ",") {
+		strategy = strings.TrimSpace(strategy)
+		discover, ok := rulerDiscoveries[strategy]
+		if !ok {
+			return rulerEndpoint{}, fmt.Errorf("unknown thanos-ruler discovery strategy [%s]", strategy)
+		}
+
+		endpoint, err := discover(ctx, d)
+		if errors.Is(err, errNotDiscovered) {
+			continue
+		}
+		if err != nil {
+			log.Error(ctx, fmt.Sprintf("Failed to discover thanos-ruler of organization %s by %s. err : ", organizationId, strategy), err)
+			continue
+		}
+
+		endpoint.Strategy = strategy
+		log.Info(ctx, fmt.Sprintf("thanos-ruler of organization %s is discovered by %s : %s", organizationId, strategy, endpoint.Url))
+		cache.Set(key, endpoint, gcache.DefaultExpiration)
+		return endpoint, nil
+	}
+	return rulerEndpoint{}, fmt.Errorf("cannot discover thanos-ruler of organization %s", organizationId)
+}
+
+func (d *rulerDiscovery) getConfig(ctx context.Context) (*rest.Config, error) {
+	if d.config != nil {
+		return d.config, nil
+	}
+	kubeconfig, err := kubernetes.GetKubeconfig(ctx, d.primaryClusterId, kubernetes.KubeconfigForAdmin)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get kubeconfig of the primary cluster")
+	}
+	d.config, err = clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return d.config, nil
+}
+
+func (d *rulerDiscovery) getClientset(ctx context.Context) (*k8s.Clientset, error) {
+	if d.clientset != nil {
+		return d.clientset, nil
+	}
+	config, err := d.getConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.clientset, err = k8s.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return d.clientset, nil
+}
+
+func (d *rulerDiscovery) getService(ctx context.Context) (*corev1.Service, error) {
+	if d.service != nil {
+		return d.service, nil
+	}
+	clientset, err := d.getClientset(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.service, err = clientset.CoreV1().Services(d.layout.Namespace).Get(ctx, d.layout.Service, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get services.")
+	}
+	return d.service, nil
+}
+
+// httpPort returns the port of the http api of thanos-ruler.
+func httpPort(service *corev1.Service) (corev1.ServicePort, error) {
+	if len(service.Spec.Ports) == 0 {
+		return corev1.ServicePort{}, fmt.Errorf("no port in service %s", service.Name)
+	}
+	for _, port := range service.Spec.Ports {
+		if port.Name == "http" || port.Name == "https" || port.Name == "web" {
+			return port, nil
+		}
+	}
+	return service.Spec.Ports[0], nil
+}
+
+func portScheme(port corev1.ServicePort) string {
+	if port.Name == "https" || port.Port == 443 {
+		return "https"
+	}
+	return "http"
+}
+
+func discoverByOverride(ctx context.Context, d *rulerDiscovery) (rulerEndpoint, error) {
+	overrides, err := parseKeyValues(viper.GetString("thanos-ruler-urls"))
+	if err != nil {
+		return rulerEndpoint{}, err
+	}
+	url, ok := overrides[d.organizationId]
+	if !ok {
+		return rulerEndpoint{}, errNotDiscovered
+	}
+	return rulerEndpoint{Url: strings.TrimSuffix(url, "/")}, nil
+}
+
+func discoverByEndpointSecret(ctx context.Context, d *rulerDiscovery) (rulerEndpoint, error) {
+	clientset_admin, err := kubernetes.GetClientAdminCluster(ctx)
+	if err != nil {
+		return rulerEndpoint{}, errors.Wrap(err, "Failed to get client set for admin cluster")
+	}
+
+	secrets, err := clientset_admin.CoreV1().Secrets(d.primaryClusterId).Get(ctx, d.layout.EndpointSecret, metav1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		return rulerEndpoint{}, errNotDiscovered
+	}
+	if err != nil {
+		return rulerEndpoint{}, err
+	}
+	address := string(secrets.Data["thanos-ruler"])
+	if address == "" {
+		return rulerEndpoint{}, errNotDiscovered
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return rulerEndpoint{Url: address}, nil
+}
+
+func discoverByIngress(ctx context.Context, d *rulerDiscovery) (rulerEndpoint, error) {
+	clientset, err := d.getClientset(ctx)
+	if err != nil {
+		return rulerEndpoint{}, err
+	}
+	ingresses, err := clientset.NetworkingV1().Ingresses(d.layout.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return rulerEndpoint{}, err
+	}
+
+	for _, ingress := range ingresses.Items {
+		for _, rule := range ingress.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for _, path := range rule.HTTP.Paths {
+				if path.Backend.Service == nil || path.Backend.Service.Name != d.layout.Service {
+					continue
+				}
+
+				host := rule.Host
+				if host == "" && len(ingress.Status.LoadBalancer.Ingress) > 0 {
+					host = ingress.Status.LoadBalancer.Ingress[0].IP
+					if host == "" {
+						host = ingress.Status.LoadBalancer.Ingress[0].Hostname
+					}
+				}
+				if host == "" {
+					continue
+				}
+
+				scheme := "http"
+				for _, tls := range ingress.Spec.TLS {
+					for _, tlsHost := range tls.Hosts {
+						if tlsHost == rule.Host {
+							scheme = "https"
+						}
+					}
+				}
+				return rulerEndpoint{Url: scheme + "://" + host + strings.TrimSuffix(path.Path, "/")}, nil
+			}
+		}
+	}
+	return rulerEndpoint{}, errNotDiscovered
+}
+
+func discoverByRoute(ctx context.Context, d *rulerDiscovery) (rulerEndpoint, error) {
+	config, err := d.getConfig(ctx)
+	if err != nil {
+		return rulerEndpoint{}, err
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return rulerEndpoint{}, err
+	}
+	routes, err := client.Resource(routeResource).Namespace(d.layout.Namespace).List(ctx, metav1.ListOptions{})
+	if k8sErrors.IsNotFound(err) {
+		// not an openshift cluster
+		return rulerEndpoint{}, errNotDiscovered
+	}
+	if err != nil {
+		return rulerEndpoint{}, err
+	}
+
+	for _, route := range routes.Items {
+		spec, _ := route.Object["spec"].(map[string]interface{})
+		to, _ := spec["to"].(map[string]interface{})
+		if name, _ := to["name"].(string); name != d.layout.Service {
+			continue
+		}
+		host, _ := spec["host"].(string)
+		if host == "" {
+			continue
+		}
+		scheme := "http"
+		if _, ok := spec["tls"]; ok {
+			scheme = "https"
+		}
+		path, _ := spec["path"].(string)
+		return rulerEndpoint{Url: scheme + "://" + host + strings.TrimSuffix(path, "/")}, nil
+	}
+	return rulerEndpoint{}, errNotDiscovered
+}
+
+func discoverByLoadBalancer(ctx context.Context, d *rulerDiscovery) (rulerEndpoint, error) {
+	service, err := d.getService(ctx)
+	if err != nil {
+		return rulerEndpoint{}, err
+	}
+	if service.Spec.Type != corev1.ServiceTypeLoadBalancer || len(service.Status.LoadBalancer.Ingress) == 0 {
+		return rulerEndpoint{}, errNotDiscovered
+	}
+	port, err := httpPort(service)
+	if err != nil {
+		return rulerEndpoint{}, err
+	}
+
+	lb := service.Status.LoadBalancer.Ingress[0]
+	host := lb.Hostname
+	if host == "" {
+		host = lb.IP
+	}
+	if host == "" {
+		return rulerEndpoint{}, errNotDiscovered
+	}
+	return rulerEndpoint{Url: portScheme(port) + "://" + net.JoinHostPort(host, strconv.Itoa(int(port.Port)))}, nil
+}
+
+func discoverByNodePort(ctx context.Context, d *rulerDiscovery) (rulerEndpoint, error) {
+	service, err := d.getService(ctx)
+	if err != nil {
+		return rulerEndpoint{}, err
+	}
+	if service.Spec.Type != corev1.ServiceTypeNodePort && service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return rulerEndpoint{}, errNotDiscovered
+	}
+	port, err := httpPort(service)
+	if err != nil {
+		return rulerEndpoint{}, err
+	}
+	if port.NodePort == 0 {
+		return rulerEndpoint{}, errNotDiscovered
+	}
+
+	clientset, err := d.getClientset(ctx)
+	if err != nil {
+		return rulerEndpoint{}, err
+	}
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return rulerEndpoint{}, err
+	}
+
+	// prefer an external address, which tks-batch can reach from outside of the cluster
+	for _, addressType := range []corev1.NodeAddressType{corev1.NodeExternalIP, corev1.NodeInternalIP} {
+		for _, node := range nodes.Items {
+			for _, address := range node.Status.Addresses {
+				if address.Type == addressType && address.Address != "" {
+					return rulerEndpoint{Url: portScheme(port) + "://" + net.JoinHostPort(address.Address, strconv.Itoa(int(port.NodePort)))}, nil
+				}
+			}
+		}
+	}
+	return rulerEndpoint{}, errNotDiscovered
+}
+
+// discoverByProxy reaches thanos-ruler through the service proxy of the api-server with the admin credential of the cluster.
+func discoverByProxy(ctx context.Context, d *rulerDiscovery) (rulerEndpoint, error) {
+	service, err := d.getService(ctx)
+	if err != nil {
+		return rulerEndpoint{}, err
+	}
+	port, err := httpPort(service)
+	if err != nil {
+		return rulerEndpoint{}, err
+	}
+	config, err := d.getConfig(ctx)
+	if err != nil {
+		return rulerEndpoint{}, err
+	}
+	client, err := rest.HTTPClientFor(config)
+	if err != nil {
+		return rulerEndpoint{}, err
+	}
+
+	url := fmt.Sprintf("%s/api/v1/namespaces/%s/services/%s:%s:%d/proxy",
+		strings.TrimSuffix(config.Host, "/"), d.layout.Namespace, portScheme(port), d.layout.Service, port.Port)
+	return rulerEndpoint{Url: url, Client: client}, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/openinfradev/tks-api/pkg/kubernetes"
+	"github.com/openinfradev/tks-api/pkg/log"
+	gcache "github.com/patrickmn/go-cache"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Keys of the ConfigMap which overrides the layout of thanos-ruler in the namespace of a cluster
+const (
+	LAYOUT_NAMESPACE       = "namespace"
+	LAYOUT_CONFIGMAP       = "configmap"
+	LAYOUT_RULE_FILE       = "rule-file"
+	LAYOUT_SERVICE         = "service"
+	LAYOUT_STATEFULSET     = "statefulset"
+	LAYOUT_ENDPOINT_SECRET = "endpoint-secret"
+)
+
+// rulerLayout is where the resources of thanos-ruler are in a cluster.
+type rulerLayout struct {
+	Namespace string
+	ConfigMap string
+	// RuleFile is the key of the rules of tks-batch in the ConfigMap.
+	RuleFile    string
+	Service     string
+	StatefulSet string
+	// EndpointSecret is in the namespace of the cluster in the admin cluster.
+	EndpointSecret string
+}
+
+func defaultRulerLayout() rulerLayout {
+	return rulerLayout{
+		Namespace:      viper.GetString("thanos-ruler-namespace"),
+		ConfigMap:      viper.GetString("thanos-ruler-configmap"),
+		RuleFile:       viper.GetString("thanos-ruler-rule-file"),
+		Service:        viper.GetString("thanos-ruler-service"),
+		StatefulSet:    viper.GetString("thanos-ruler-statefulset"),
+		EndpointSecret: viper.GetString("thanos-ruler-endpoint-secret"),
+	}
+}
+
+// getRulerLayout returns the layout of thanos-ruler in the cluster,
+// which is the global one overridden by the layout ConfigMap of the cluster in the admin cluster.
+func getRulerLayout(ctx context.Context, clusterId string) (rulerLayout, error) {
+	const prefix = "CACHE_KEY_THANOS_RULER_LAYOUT"
+	if value, found := cache.Get(prefix + clusterId); found {
+		return value.(rulerLayout), nil
+	}
+
+	layout := defaultRulerLayout()
+	if name := viper.GetString("thanos-ruler-layout-configmap"); name != "" {
+		clientset_admin, err := kubernetes.GetClientAdminCluster(ctx)
+		if err != nil {
+			return layout, errors.Wrap(err, "Failed to get client set for admin cluster")
+		}
+		cm, err := clientset_admin.CoreV1().ConfigMaps(clusterId).Get(ctx, name, metav1.GetOptions{})
+		if err != nil && !k8sErrors.IsNotFound(err) {
+			return layout, err
+		}
+		if err == nil {
+			for key, field := range map[string]*string{
+				LAYOUT_NAMESPACE:       &layout.Namespace,
+				LAYOUT_CONFIGMAP:       &layout.ConfigMap,
+				LAYOUT_RULE_FILE:       &layout.RuleFile,
+				LAYOUT_SERVICE:         &layout.Service,
+				LAYOUT_STATEFULSET:     &layout.StatefulSet,
+				LAYOUT_ENDPOINT_SECRET: &layout.EndpointSecret,
+			} {
+				if value := cm.Data[key]; value != "" {
+					*field = value
+				}
+			}
+			log.Info(ctx, fmt.Sprintf("layout of thanos-ruler in cluster %s is overridden by %s : %+v", clusterId, name, layout))
+		}
+	}
+
+	cache.Set(prefix+clusterId, layout, gcache.DefaultExpiration)
+	return layout, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/openinfradev/tks-api/pkg/log"
+	"github.com/openinfradev/tks-batch/internal/metrics"
+	ruleStatus "github.com/openinfradev/tks-batch/internal/rule-status"
+	rulerReload "github.com/openinfradev/tks-batch/internal/ruler-reload"
+	"github.com/spf13/viper"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Strategies to make thanos-ruler load the updated rules
+const (
+	// ReloadHttp calls /-/reload of thanos-ruler.
+	ReloadHttp = "http"
+	// ReloadRollout restarts thanos-ruler by the hash of the rules in the pod template of the StatefulSet.
+	ReloadRollout = "rollout"
+	// ReloadSidecar waits for the config-reloader sidecar of thanos-ruler.
+	ReloadSidecar = "sidecar"
+)
+
+func isReloadStrategy(strategy string) bool {
+	return strategy == ReloadHttp || strategy == ReloadRollout || strategy == ReloadSidecar
+}
+
+// reloadThanosRuler makes thanos-ruler of the organization load the pending rules by the strategy and verifies them.
+// It reports false while thanos-ruler is still expected to load them, and fails if it has not loaded them after the wait.
+func reloadThanosRuler(ctx context.Context, pending rulerReload.RulerReload, primaryClusterId string, strategy string) (done bool, err error) {
+	organizationId := pending.OrganizationId
+	// the kubelet takes a while to update the mounted ConfigMap, so thanos-ruler may read the previous rules for every strategy
+	waitUntil := pending.AppliedAt.Add(time.Second * time.Duration(viper.GetInt("thanos-ruler-reload-wait-sec")))
+
+	if strategy == ReloadRollout {
+		ready, err := rolloutThanosRuler(ctx, primaryClusterId)
+		if err != nil {
+			metrics.ThanosReloads.WithLabelValues(strategy, metrics.ResultError).Inc()
+			return false, err
+		}
+		if !ready {
+			if time.Now().Before(waitUntil) {
+				log.Info(ctx, fmt.Sprintf("waiting for the rollout of thanos-ruler of organization %s", organizationId))
+				return false, nil
+			}
+			log.Error(ctx, fmt.Sprintf("rollout of thanos-ruler of organization %s has not completed until %s", organizationId, waitUntil))
+		}
+	}
+
+	endpoint, err := getThanosRulerEndpoint(ctx, organizationId, primaryClusterId)
+	if err != nil {
+		return false, err
+	}
+	client, err := newThanosRulerClient(ctx, primaryClusterId, endpoint)
+	if err != nil {
+		return false, fmt.Errorf("Failed to make thanos-ruler client. err : %s", err)
+	}
+
+	// thanos-ruler is requested to reload once per attempt. the later runs of the attempt only verify the rules.
+	if strategy == ReloadHttp && !pending.ReloadRequested() {
+		if err = client.Reload(ctx); err != nil {
+			metrics.ThanosReloads.WithLabelValues(strategy, metrics.ResultError).Inc()
+			return false, fmt.Errorf("Failed to reload thanos-ruler. err : %s", err)
+		}
+		if err = rulerReloadAccessor.SetReloadRequested(organizationId, pending.AppliedHash, pending.Attempts); err != nil {
+			return false, err
+		}
+	}
+
+	loaded, err := verifyRules(ctx, organizationId, client, waitUntil)
+	if err != nil {
+		return false, fmt.Errorf("Failed to verify rules. err : %s", err)
+	}
+	if !loaded {
+		if time.Now().Before(waitUntil) {
+			log.Info(ctx, fmt.Sprintf("waiting for thanos-ruler of organization %s to load the rules", organizationId))
+			return false, nil
+		}
+		metrics.ThanosReloads.WithLabelValues(strategy, metrics.ResultError).Inc()
+		return false, fmt.Errorf("thanos-ruler has not loaded the applied rules until %s", waitUntil.Format(time.RFC3339))
+	}
+	metrics.ThanosReloads.WithLabelValues(strategy, metrics.ResultSuccess).Inc()
+	return true, nil
+}
+
+// rolloutThanosRuler sets the hash of the rules in the ConfigMap to the pod template of the StatefulSet,
+// which restarts thanos-ruler when the hash changes. It reports whether the rollout has completed.
+func rolloutThanosRuler(ctx context.Context, primaryClusterId string) (ready bool, err error) {
+	clientset, cm, layout, err := getRulerConfigMap(ctx, primaryClusterId)
+	if err != nil {
+		return false, err
+	}
+	hash := cm.Annotations[RULES_HASH_ANNOTATION]
+	if hash == "" {
+		return false, fmt.Errorf("no %s annotation in the ConfigMap of thanos-ruler", RULES_HASH_ANNOTATION)
+	}
+
+	statefulSets := clientset.AppsV1().StatefulSets(layout.Namespace)
+	sts, err := statefulSets.Get(ctx, layout.StatefulSet, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	if sts.Spec.Template.Annotations[RULES_HASH_ANNOTATION] != hash {
+		patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{%q:%q}}}}}`, RULES_HASH_ANNOTATION, hash)
+		sts, err = statefulSets.Patch(ctx, layout.StatefulSet, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{})
+		if err != nil {
+			return false, err
+		}
+		log.Info(ctx, fmt.Sprintf("rolling out thanos-ruler of cluster %s with rules %s", primaryClusterId, hash))
+	}
+	return isRolledOut(sts), nil
+}
+
+func isRolledOut(sts *appsv1.StatefulSet) bool {
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	return sts.Status.ObservedGeneration >= sts.Generation &&
+		sts.Status.UpdateRevision == sts.Status.CurrentRevision &&
+		sts.Status.UpdatedReplicas == replicas &&
+		sts.Status.ReadyReplicas == replicas
+}
+
+// statusesToVerify returns the statuses of the rules which thanos-ruler should have loaded.
+func statusesToVerify(statuses []ruleStatus.RuleStatus) []ruleStatus.RuleStatus {
+	out := make([]ruleStatus.RuleStatus, 0, len(statuses))
+	for _, status := range statuses {
+		if status.Status == ruleStatus.StatusApplied || status.Status == ruleStatus.StatusNotLoaded {
+			out = append(out, status)
+		}
+	}
+	return out
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	ruleStatus "github.com/openinfradev/tks-batch/internal/rule-status"
+	thanosRuler "github.com/openinfradev/tks-batch/internal/thanos-ruler"
+	"github.com/stretchr/testify/require"
+)
+
+func newLoadedRule(ruleId string, severity string, hash string) thanosRuler.Rule {
+	return thanosRuler.Rule{
+		Labels:      map[string]string{"severity": severity, "systemNotificationRuleId": ruleId},
+		Annotations: map[string]string{RULE_CONTENT_HASH_ANNOTATION: hash},
+		Health:      thanosRuler.HealthOk,
+	}
+}
+
+func TestCompareLoadedRules(t *testing.T) {
+	statuses := []ruleStatus.RuleStatus{
+		{RuleId: "r1", ContentHash: "h1", Status: ruleStatus.StatusApplied},
+		{RuleId: "r2", ContentHash: "h2", Status: ruleStatus.StatusNotLoaded},
+	}
+
+	testCases := []struct {
+		name        string
+		groups      []thanosRuler.Group
+		wantReasons map[string]string
+		wantDeleted []string
+	}{
+		{
+			name: "loaded",
+			groups: []thanosRuler.Group{
+				{Name: "tks-t1", Rules: []thanosRuler.Rule{newLoadedRule("r1", "warning", "h1"), newLoadedRule("r1", "critical", "h1")}},
+				{Name: "tks-t2", Rules: []thanosRuler.Rule{newLoadedRule("r2", "warning", "h2")}},
+			},
+			wantReasons: map[string]string{},
+			wantDeleted: []string{},
+		},
+		{
+			name: "not found",
+			groups: []thanosRuler.Group{
+				{Name: "tks-t1", Rules: []thanosRuler.Rule{newLoadedRule("r1", "warning", "h1")}},
+			},
+			wantReasons: map[string]string{"r2": "not found in thanos-ruler"},
+			wantDeleted: []string{},
+		},
+		{
+			name: "edited rule with the old version loaded",
+			groups: []thanosRuler.Group{
+				{Name: "tks-t1", Rules: []thanosRuler.Rule{newLoadedRule("r1", "warning", "h0")}},
+				{Name: "tks-t2", Rules: []thanosRuler.Rule{newLoadedRule("r2", "warning", "h2")}},
+			},
+			wantReasons: map[string]string{"r1": "an outdated version is loaded in thanos-ruler"},
+			wantDeleted: []string{},
+		},
+		{
+			name: "removed condition still loaded",
+			groups: []thanosRuler.Group{
+				{Name: "tks-t1", Rules: []thanosRuler.Rule{newLoadedRule("r1", "warning", "h1"), newLoadedRule("r1", "critical", "h0")}},
+				{Name: "tks-t2", Rules: []thanosRuler.Rule{newLoadedRule("r2", "warning", "h2")}},
+			},
+			wantReasons: map[string]string{"r1": "an outdated version is loaded in thanos-ruler"},
+			wantDeleted: []string{},
+		},
+		{
+			name: "deleted rules still loaded",
+			groups: []thanosRuler.Group{
+				{Name: "tks-t1", Rules: []thanosRuler.Rule{newLoadedRule("r1", "warning", "h1"), newLoadedRule("r4", "warning", "h4")}},
+				{Name: "tks-t2", Rules: []thanosRuler.Rule{newLoadedRule("r2", "warning", "h2"), newLoadedRule("r3", "warning", "h3")}},
+			},
+			wantReasons: map[string]string{},
+			wantDeleted: []string{"r3", "r4"},
+		},
+		{
+			name: "groups of operators are ignored",
+			groups: []thanosRuler.Group{
+				{Name: "operator", Rules: []thanosRuler.Rule{newLoadedRule("r1", "warning", "h0"), newLoadedRule("r3", "warning", "h3")}},
+				{Name: "tks-t1", Rules: []thanosRuler.Rule{newLoadedRule("r1", "warning", "h1")}},
+				{Name: "tks-t2", Rules: []thanosRuler.Rule{newLoadedRule("r2", "warning", "h2")}},
+			},
+			wantReasons: map[string]string{},
+			wantDeleted: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, reasons, deleted := compareLoadedRules(statuses, tc.groups)
+			require.Equal(t, tc.wantReasons, reasons)
+			require.Equal(t, tc.wantDeleted, deleted)
+		})
+	}
+}
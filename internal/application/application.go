@@ -12,9 +12,12 @@ import (
 
 type AppGroup struct {
 	ID         string `gorm:"primarykey"`
+	ClusterId  string
 	WorkflowId string
 	Status     domain.AppGroupStatus
 	StatusDesc string
+	// OrganizationId is read from the cluster of the appgroup.
+	OrganizationId string `gorm:"->;-:migration"`
 }
 
 type ApplicationAccessor struct {
@@ -37,7 +40,9 @@ func (x *ApplicationAccessor) GetIncompleteAppGroups() ([]AppGroup, error) {
 	var appGroups []AppGroup
 
 	res := x.db.
-		Where("status IN ?", []domain.AppGroupStatus{domain.AppGroupStatus_INSTALLING, domain.AppGroupStatus_DELETING}).
+		Select("app_groups.*, clusters.organization_id").
+		Joins("LEFT JOIN clusters ON clusters.id = app_groups.cluster_id").
+		Where("app_groups.status IN ?", []domain.AppGroupStatus{domain.AppGroupStatus_INSTALLING, domain.AppGroupStatus_DELETING}).
 		Find(&appGroups)
 
 	if res.Error != nil {
@@ -11,10 +11,11 @@ import (
 )
 
 type CloudAccount struct {
-	ID         string `gorm:"primarykey"`
-	WorkflowId string
-	Status     domain.CloudAccountStatus
-	StatusDesc string
+	ID             string `gorm:"primarykey"`
+	OrganizationId string
+	WorkflowId     string
+	Status         domain.CloudAccountStatus
+	StatusDesc     string
 }
 
 type CloudAccountAccessor struct {
@@ -0,0 +1,67 @@
+package event
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DeadLetter is an event which could not be delivered to a sink.
+type DeadLetter struct {
+	ID        uint   `gorm:"primarykey"`
+	EventId   string `gorm:"index"`
+	Sink      string
+	Kind      string
+	EntityId  string
+	Payload   string
+	Attempts  int
+	Error     string
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// DeadLetterAccessor accesses dead letters in DB.
+type DeadLetterAccessor struct {
+	db *gorm.DB
+}
+
+// New returns new accessor's ptr.
+func New(db *gorm.DB) *DeadLetterAccessor {
+	return &DeadLetterAccessor{
+		db: db,
+	}
+}
+
+// For Unittest
+func (x *DeadLetterAccessor) GetDb() *gorm.DB {
+	return x.db
+}
+
+// Migrate creates the table owned by tks-batch.
+func (x *DeadLetterAccessor) Migrate() error {
+	return x.db.AutoMigrate(&DeadLetter{})
+}
+
+func (x *DeadLetterAccessor) Record(sink string, e Event, attempts int, cause error) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	deadLetter := DeadLetter{
+		EventId:  e.ID,
+		Sink:     sink,
+		Kind:     e.Kind,
+		EntityId: e.EntityId,
+		Payload:  string(payload),
+		Attempts: attempts,
+	}
+	if cause != nil {
+		deadLetter.Error = cause.Error()
+	}
+
+	res := x.db.Create(&deadLetter)
+	if res.Error != nil {
+		return res.Error
+	}
+	return nil
+}
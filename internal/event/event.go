@@ -0,0 +1,157 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/openinfradev/tks-api/pkg/log"
+	"github.com/openinfradev/tks-batch/internal/metrics"
+)
+
+const QUEUE_SIZE = 1000
+
+// Event is a status transition of an entity delivered to the sinks.
+type Event struct {
+	ID             string    `json:"id"`
+	Kind           string    `json:"kind"`
+	EntityId       string    `json:"entityId"`
+	OrganizationId string    `json:"organizationId"`
+	OldStatus      string    `json:"oldStatus"`
+	NewStatus      string    `json:"newStatus"`
+	Message        string    `json:"message"`
+	WorkflowId     string    `json:"workflowId,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// Sink delivers events to a destination.
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, e Event) error
+}
+
+type worker struct {
+	sink  Sink
+	queue chan Event
+}
+
+// Publisher delivers events to every sink asynchronously.
+// Each sink has its own queue, so that a slow sink does not hold back the others.
+// An event which cannot be delivered after maxAttempts is recorded as a dead letter.
+type Publisher struct {
+	workers     []worker
+	maxAttempts int
+	backoff     time.Duration
+	deadLetters *DeadLetterAccessor
+}
+
+func NewPublisher(sinks []Sink, deadLetters *DeadLetterAccessor, maxAttempts int, backoff time.Duration) *Publisher {
+	p := &Publisher{
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		deadLetters: deadLetters,
+	}
+	if p.maxAttempts < 1 {
+		p.maxAttempts = 1
+	}
+	for _, sink := range sinks {
+		p.workers = append(p.workers, worker{sink: sink, queue: make(chan Event, QUEUE_SIZE)})
+	}
+	return p
+}
+
+// Publish queues the event for every sink. It never blocks.
+func (p *Publisher) Publish(ctx context.Context, e Event) {
+	if e.ID == "" {
+		id, err := uuid.NewV4()
+		if err != nil {
+			log.Error(ctx, "failed to generate event id. err : ", err)
+		}
+		e.ID = id.String()
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+
+	for _, w := range p.workers {
+		select {
+		case w.queue <- e:
+		default:
+			p.deadLetter(ctx, w.sink, e, 0, fmt.Errorf("queue of sink %s is full", w.sink.Name()))
+		}
+	}
+}
+
+// Run delivers queued events until ctx is done.
+// The events still queued then are recorded as dead letters before Run returns.
+func (p *Publisher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, w := range p.workers {
+		wg.Add(1)
+		go func(w worker) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					p.drain(context.WithoutCancel(ctx), w, ctx.Err())
+					return
+				case e := <-w.queue:
+					p.deliver(ctx, w.sink, e)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// drain records the events left in the queue of w as dead letters.
+func (p *Publisher) drain(ctx context.Context, w worker, cause error) {
+	drained := 0
+	for {
+		select {
+		case e := <-w.queue:
+			p.deadLetter(ctx, w.sink, e, 0, cause)
+			drained++
+		default:
+			if drained > 0 {
+				log.Info(ctx, fmt.Sprintf("recorded %d undelivered events of sink %s as dead letters on shutdown", drained, w.sink.Name()))
+			}
+			return
+		}
+	}
+}
+
+func (p *Publisher) deliver(ctx context.Context, sink Sink, e Event) {
+	var err error
+	for attempt := 1; attempt <= p.maxAttempts; attempt++ {
+		if err = sink.Send(ctx, e); err == nil {
+			metrics.EventDeliveries.WithLabelValues(sink.Name(), metrics.ResultSuccess).Inc()
+			return
+		}
+		log.Error(ctx, fmt.Sprintf("failed to deliver event %s to %s (attempt %d/%d). err : ", e.ID, sink.Name(), attempt, p.maxAttempts), err)
+		if attempt == p.maxAttempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			p.deadLetter(ctx, sink, e, attempt, ctx.Err())
+			return
+		case <-time.After(p.backoff << (attempt - 1)):
+		}
+	}
+	p.deadLetter(ctx, sink, e, p.maxAttempts, err)
+}
+
+func (p *Publisher) deadLetter(ctx context.Context, sink Sink, e Event, attempts int, cause error) {
+	metrics.EventDeliveries.WithLabelValues(sink.Name(), metrics.ResultError).Inc()
+	if p.deadLetters == nil {
+		log.Error(ctx, fmt.Sprintf("dropped event %s of %s [%s] for sink %s. err : ", e.ID, e.Kind, e.EntityId, sink.Name()), cause)
+		return
+	}
+	if err := p.deadLetters.Record(sink.Name(), e, attempts, cause); err != nil {
+		log.Error(ctx, fmt.Sprintf("failed to record dead letter of event %s. err : ", e.ID), err)
+	}
+}
@@ -0,0 +1,119 @@
+package event
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	_apiClient "github.com/openinfradev/tks-api/pkg/api-client"
+	"github.com/openinfradev/tks-api/pkg/log"
+)
+
+// Sink names
+const (
+	SinkLog     = "log"
+	SinkTksApi  = "tks-api"
+	SinkWebhook = "webhook"
+)
+
+// SIGNATURE_HEADER carries "sha256=<hex of HMAC-SHA256 of the body>" when the webhook has a secret.
+const SIGNATURE_HEADER = "X-Tks-Signature"
+
+type logSink struct{}
+
+// NewLogSink returns a sink which writes events to the log.
+func NewLogSink() Sink {
+	return &logSink{}
+}
+
+func (s *logSink) Name() string {
+	return SinkLog
+}
+
+func (s *logSink) Send(ctx context.Context, e Event) error {
+	log.Info(ctx, fmt.Sprintf("[event] %s [%s] of organization [%s] : %s -> %s. %s", e.Kind, e.EntityId, e.OrganizationId, e.OldStatus, e.NewStatus, e.Message))
+	return nil
+}
+
+type apiSink struct {
+	client _apiClient.ApiClient
+	path   string
+	login  func() string
+}
+
+// NewApiSink returns a sink which posts events to path of tks-api.
+// login is called before every post and is expected to refresh the token of client.
+func NewApiSink(client _apiClient.ApiClient, path string, login func() string) Sink {
+	return &apiSink{
+		client: client,
+		path:   path,
+		login:  login,
+	}
+}
+
+func (s *apiSink) Name() string {
+	return SinkTksApi
+}
+
+func (s *apiSink) Send(ctx context.Context, e Event) error {
+	if s.login != nil {
+		s.login()
+	}
+	_, err := s.client.Post(s.path, e)
+	return err
+}
+
+type webhookSink struct {
+	url    string
+	secret []byte
+	http   *http.Client
+}
+
+// NewWebhookSink returns a sink which posts events to url.
+// The body is signed with secret unless it is empty.
+func NewWebhookSink(url string, secret string) Sink {
+	return &webhookSink{
+		url:    url,
+		secret: []byte(secret),
+		http:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *webhookSink) Name() string {
+	return SinkWebhook
+}
+
+func (s *webhookSink) Send(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(s.secret) > 0 {
+		mac := hmac.New(sha256.New, s.secret)
+		mac.Write(body)
+		req.Header.Set(SIGNATURE_HEADER, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Invalid http status. return code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package history
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// StatusHistory is a status transition of an entity.
+type StatusHistory struct {
+	ID         uint   `gorm:"primarykey"`
+	Kind       string `gorm:"index:idx_status_histories_entity"`
+	EntityId   string `gorm:"index:idx_status_histories_entity"`
+	OldStatus  string
+	NewStatus  string
+	Message    string
+	WorkflowId string
+	Processor  string
+	CreatedAt  time.Time `gorm:"index"`
+}
+
+// HistoryAccessor accesses status histories in DB.
+type HistoryAccessor struct {
+	db *gorm.DB
+}
+
+// New returns new accessor's ptr.
+func New(db *gorm.DB) *HistoryAccessor {
+	return &HistoryAccessor{
+		db: db,
+	}
+}
+
+// For Unittest
+func (x *HistoryAccessor) GetDb() *gorm.DB {
+	return x.db
+}
+
+// Migrate creates the table owned by tks-batch.
+func (x *HistoryAccessor) Migrate() error {
+	return x.db.AutoMigrate(&StatusHistory{})
+}
+
+func (x *HistoryAccessor) Record(history StatusHistory) error {
+	res := x.db.Create(&history)
+	if res.Error != nil {
+		return res.Error
+	}
+	return nil
+}
+
+// GetTimeline returns the transitions of an entity, oldest first.
+func (x *HistoryAccessor) GetTimeline(kind string, entityId string) ([]StatusHistory, error) {
+	var histories []StatusHistory
+
+	res := x.db.
+		Where("kind = ? AND entity_id = ?", kind, entityId).
+		Order("created_at, id").
+		Find(&histories)
+
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return histories, nil
+}
+
+// DeleteOlderThan deletes transitions recorded before the retention period.
+func (x *HistoryAccessor) DeleteOlderThan(retention time.Duration) (int64, error) {
+	res := x.db.
+		Where("created_at < ?", time.Now().Add(-retention)).
+		Delete(&StatusHistory{})
+
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	return res.RowsAffected, nil
+}
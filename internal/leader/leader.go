@@ -0,0 +1,180 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/openinfradev/tks-api/pkg/kubernetes"
+	"github.com/openinfradev/tks-api/pkg/log"
+	"gorm.io/gorm"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Election modes
+const (
+	ModeNone     = "none"
+	ModeLease    = "lease"
+	ModePostgres = "postgres"
+)
+
+type Config struct {
+	Mode          string
+	Identity      string
+	Namespace     string
+	Name          string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+	// LockKey is the key of the postgreSQL advisory lock.
+	LockKey int64
+}
+
+// Run blocks until this instance becomes the leader, and then calls run with a context
+// which is cancelled when the leadership is lost or ctx is done.
+// Run returns only after run has returned, and the leadership is released only after that,
+// so that another instance never runs while this one is still running.
+func Run(ctx context.Context, cfg Config, db *gorm.DB, run func(ctx context.Context)) error {
+	switch cfg.Mode {
+	case ModeNone:
+		run(ctx)
+		return nil
+	case ModeLease:
+		return runWithLease(ctx, cfg, run)
+	case ModePostgres:
+		return runWithAdvisoryLock(ctx, cfg, db, run)
+	}
+	return fmt.Errorf("invalid leader election mode %s", cfg.Mode)
+}
+
+func runWithLease(ctx context.Context, cfg Config, run func(ctx context.Context)) error {
+	clientset, err := kubernetes.GetClientAdminCluster(ctx)
+	if err != nil {
+		return err
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      cfg.Name,
+			Namespace: cfg.Namespace,
+		},
+		Client: clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: cfg.Identity,
+		},
+	}
+
+	// the election outlives ctx until run has returned, so that the lease is released only after that
+	electionCtx, cancelElection := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelElection()
+	var started atomic.Bool
+	runDone := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		if !started.Load() {
+			cancelElection()
+		}
+	})
+	defer stop()
+
+	leaderelection.RunOrDie(electionCtx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   cfg.LeaseDuration,
+		RenewDeadline:   cfg.RenewDeadline,
+		RetryPeriod:     cfg.RetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leadCtx context.Context) {
+				started.Store(true)
+				defer close(runDone)
+				defer cancelElection()
+
+				runCtx, cancel := context.WithCancel(leadCtx)
+				defer cancel()
+				stopRun := context.AfterFunc(ctx, cancel)
+				defer stopRun()
+				if runCtx.Err() != nil {
+					return
+				}
+				log.Info(runCtx, fmt.Sprintf("[leader] %s started leading", cfg.Identity))
+				run(runCtx)
+			},
+			OnStoppedLeading: func() {
+				log.Info(context.TODO(), fmt.Sprintf("[leader] %s stopped leading", cfg.Identity))
+			},
+			OnNewLeader: func(identity string) {
+				if identity != cfg.Identity {
+					log.Info(context.TODO(), fmt.Sprintf("[leader] current leader is %s", identity))
+				}
+			},
+		},
+	})
+	// RunOrDie returns on lost leadership without waiting for OnStartedLeading
+	if started.Load() {
+		<-runDone
+	}
+	return nil
+}
+
+func runWithAdvisoryLock(ctx context.Context, cfg Config, db *gorm.DB, run func(ctx context.Context)) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	// advisory locks belong to a session, so hold a dedicated connection while leading.
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for {
+		var locked bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", cfg.LockKey).Scan(&locked); err != nil {
+			return err
+		}
+		if locked {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(cfg.RetryPeriod):
+		}
+	}
+	log.Info(ctx, fmt.Sprintf("[leader] %s started leading", cfg.Identity))
+
+	leadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		ticker := time.NewTicker(cfg.RetryPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-leadCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			pingCtx, pingCancel := context.WithTimeout(leadCtx, cfg.RenewDeadline)
+			err := conn.PingContext(pingCtx)
+			pingCancel()
+			if err != nil && leadCtx.Err() == nil {
+				log.Error(leadCtx, "[leader] lost the connection holding the advisory lock. err : ", err)
+				cancel()
+				return
+			}
+		}
+	}()
+
+	run(leadCtx)
+	cancel()
+
+	if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", cfg.LockKey); err != nil {
+		log.Error(context.TODO(), "[leader] failed to release the advisory lock. err : ", err)
+	}
+	log.Info(context.TODO(), fmt.Sprintf("[leader] %s stopped leading", cfg.Identity))
+	return nil
+}
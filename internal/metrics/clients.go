@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	_apiClient "github.com/openinfradev/tks-api/pkg/api-client"
+	argo "github.com/openinfradev/tks-api/pkg/argo-client"
+)
+
+type argoClient struct {
+	client argo.ArgoClient
+}
+
+// InstrumentArgoClient returns an argo client which records the latency and errors of every request.
+func InstrumentArgoClient(client argo.ArgoClient) argo.ArgoClient {
+	return &argoClient{client: client}
+}
+
+func observeArgo(operation string, start time.Time, err error) {
+	ArgoRequestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		ArgoRequestErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+func (c *argoClient) GetWorkflowTemplates(ctx context.Context, namespace string) (out *argo.GetWorkflowTemplatesResponse, err error) {
+	defer func(start time.Time) { observeArgo("GetWorkflowTemplates", start, err) }(time.Now())
+	return c.client.GetWorkflowTemplates(ctx, namespace)
+}
+
+func (c *argoClient) GetWorkflow(ctx context.Context, namespace string, workflowName string) (out *argo.Workflow, err error) {
+	defer func(start time.Time) { observeArgo("GetWorkflow", start, err) }(time.Now())
+	return c.client.GetWorkflow(ctx, namespace, workflowName)
+}
+
+func (c *argoClient) IsPausedWorkflow(ctx context.Context, namespace string, workflowName string) (out bool, err error) {
+	defer func(start time.Time) { observeArgo("IsPausedWorkflow", start, err) }(time.Now())
+	return c.client.IsPausedWorkflow(ctx, namespace, workflowName)
+}
+
+func (c *argoClient) GetWorkflowLog(ctx context.Context, namespace string, container string, workflowName string) (logs string, err error) {
+	defer func(start time.Time) { observeArgo("GetWorkflowLog", start, err) }(time.Now())
+	return c.client.GetWorkflowLog(ctx, namespace, container, workflowName)
+}
+
+func (c *argoClient) GetWorkflows(ctx context.Context, namespace string) (out *argo.GetWorkflowsResponse, err error) {
+	defer func(start time.Time) { observeArgo("GetWorkflows", start, err) }(time.Now())
+	return c.client.GetWorkflows(ctx, namespace)
+}
+
+func (c *argoClient) SumbitWorkflowFromWftpl(ctx context.Context, wftplName string, opts argo.SubmitOptions) (out string, err error) {
+	defer func(start time.Time) { observeArgo("SumbitWorkflowFromWftpl", start, err) }(time.Now())
+	return c.client.SumbitWorkflowFromWftpl(ctx, wftplName, opts)
+}
+
+func (c *argoClient) ResumeWorkflow(ctx context.Context, namespace string, workflowName string) (out *argo.Workflow, err error) {
+	defer func(start time.Time) { observeArgo("ResumeWorkflow", start, err) }(time.Now())
+	return c.client.ResumeWorkflow(ctx, namespace, workflowName)
+}
+
+type apiClient struct {
+	client _apiClient.ApiClient
+	// tokenMu guards the token of client, which every request reads without synchronization.
+	tokenMu sync.RWMutex
+}
+
+// InstrumentApiClient returns a tks-api client which counts the results of every request.
+func InstrumentApiClient(client _apiClient.ApiClient) _apiClient.ApiClient {
+	return &apiClient{client: client}
+}
+
+func observeApi(method string, err error) {
+	TksApiRequests.WithLabelValues(method, Result(err)).Inc()
+}
+
+func (c *apiClient) Get(path string) (out interface{}, err error) {
+	defer func() { observeApi("GET", err) }()
+	c.tokenMu.RLock()
+	defer c.tokenMu.RUnlock()
+	return c.client.Get(path)
+}
+
+func (c *apiClient) Post(path string, input interface{}) (out interface{}, err error) {
+	defer func() { observeApi("POST", err) }()
+	c.tokenMu.RLock()
+	defer c.tokenMu.RUnlock()
+	return c.client.Post(path, input)
+}
+
+func (c *apiClient) Delete(path string, input interface{}) (out interface{}, err error) {
+	defer func() { observeApi("DELETE", err) }()
+	c.tokenMu.RLock()
+	defer c.tokenMu.RUnlock()
+	return c.client.Delete(path, input)
+}
+
+func (c *apiClient) Put(path string, input interface{}) (out interface{}, err error) {
+	defer func() { observeApi("PUT", err) }()
+	c.tokenMu.RLock()
+	defer c.tokenMu.RUnlock()
+	return c.client.Put(path, input)
+}
+
+func (c *apiClient) Patch(path string, input interface{}) (out interface{}, err error) {
+	defer func() { observeApi("PATCH", err) }()
+	c.tokenMu.RLock()
+	defer c.tokenMu.RUnlock()
+	return c.client.Patch(path, input)
+}
+
+func (c *apiClient) SetToken(token string) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	c.client.SetToken(token)
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "tks_batch"
+
+// Result label values
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+	ResultSkipped = "skipped"
+)
+
+var (
+	ProcessorRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "processor_runs_total",
+		Help:      "Number of processor runs.",
+	}, []string{"processor"})
+
+	ProcessorErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "processor_errors_total",
+		Help:      "Number of processor runs which returned an error.",
+	}, []string{"processor"})
+
+	ProcessorDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "processor_duration_seconds",
+		Help:      "Duration of processor runs.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 8),
+	}, []string{"processor"})
+
+	EntityStatus = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "entities",
+		Help:      "Number of incomplete entities in each status.",
+	}, []string{"kind", "status"})
+
+	StatusTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "status_transitions_total",
+		Help:      "Number of status transitions made by tks-batch.",
+	}, []string{"kind", "from", "to"})
+
+	ArgoRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "argo_request_duration_seconds",
+		Help:      "Latency of argo-server requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	ArgoRequestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "argo_request_errors_total",
+		Help:      "Number of failed argo-server requests.",
+	}, []string{"operation"})
+
+	TksApiRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tks_api_requests_total",
+		Help:      "Number of tks-api requests by result.",
+	}, []string{"method", "result"})
+
+	ConfigMapApplies = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "configmap_applies_total",
+		Help:      "Number of thanos-ruler ConfigMap applies by result.",
+	}, []string{"result"})
+
+	ThanosReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "thanos_reloads_total",
+		Help:      "Number of thanos-ruler reloads by strategy and result.",
+	}, []string{"strategy", "result"})
+
+	ThanosRuleVerifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "thanos_rule_verifications_total",
+		Help:      "Number of applied rules checked in thanos-ruler after reloads by result.",
+	}, []string{"result"})
+
+	EventDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "event_deliveries_total",
+		Help:      "Number of status event deliveries by sink and result.",
+	}, []string{"sink", "result"})
+)
+
+// Result returns the result label value of err.
+func Result(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return ResultSuccess
+}
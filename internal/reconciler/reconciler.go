@@ -0,0 +1,383 @@
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	argo "github.com/openinfradev/tks-api/pkg/argo-client"
+	"github.com/openinfradev/tks-api/pkg/log"
+	"github.com/openinfradev/tks-batch/internal/metrics"
+	"github.com/openinfradev/tks-batch/internal/workflow"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Argo workflow phases.
+const (
+	PhasePending   = "Pending"
+	PhaseRunning   = "Running"
+	PhaseSucceeded = "Succeeded"
+	PhaseFailed    = "Failed"
+	PhaseError     = "Error"
+	PhaseStopped   = "Stopped"
+
+	// PhasePaused is not reported by argo itself.
+	// It is used for a running workflow which has a suspended node.
+	PhasePaused = "Paused"
+)
+
+// Status is a domain status such as domain.ClusterStatus.
+type Status interface {
+	~int32
+	String() string
+}
+
+// Transition is a single row of a transition table.
+// An entity in From status moves to To status when its workflow reports Phase.
+type Transition[S Status] struct {
+	From  S
+	Phase string
+	To    S
+}
+
+// Entity is a row whose status follows an argo workflow.
+type Entity[S Status] struct {
+	ID             string
+	OrganizationId string
+	WorkflowId     string
+	Status         S
+	StatusDesc     string
+}
+
+// Event is a status transition of an entity.
+type Event struct {
+	Kind           string
+	EntityId       string
+	OrganizationId string
+	WorkflowId     string
+	OldStatus      string
+	NewStatus      string
+	Message        string
+}
+
+// Observer is notified of every status transition.
+type Observer func(ctx context.Context, e Event)
+
+// RetryPolicy is how a kind retries its failed workflows.
+type RetryPolicy struct {
+	MaxAttempts int
+	// Backoff is the wait before the second attempt. It doubles on every attempt.
+	Backoff time.Duration
+}
+
+// WorkflowRetrier retries a failed workflow.
+type WorkflowRetrier interface {
+	RetryWorkflow(ctx context.Context, namespace string, workflowName string) error
+}
+
+// RetryStore keeps how many times a workflow has been retried.
+type RetryStore interface {
+	Attempts(kind string, entityId string, workflowId string) (int, time.Time, error)
+	AddAttempt(kind string, entityId string, workflowId string) error
+}
+
+// StatusClock keeps when an entity has entered its status.
+type StatusClock interface {
+	EnteredAt(kind string, entityId string, status string) (time.Time, error)
+}
+
+// WorkflowClient is the subset of argo client used by the reconciler.
+type WorkflowClient interface {
+	GetWorkflow(ctx context.Context, namespace string, workflowName string) (*argo.Workflow, error)
+	IsPausedWorkflow(ctx context.Context, namespace string, workflowName string) (bool, error)
+}
+
+// Processor reconciles every incomplete entity of a kind.
+type Processor interface {
+	Name() string
+	Reconcile(ctx context.Context) error
+}
+
+// Reconciler holds the kinds registered to it and the workflow client they share.
+type Reconciler struct {
+	client    WorkflowClient
+	namespace string
+	kinds     map[string]Processor
+	timeouts  map[string]map[string]time.Duration
+	clock     StatusClock
+	observers []Observer
+
+	retryPolicies map[string]RetryPolicy
+	retrier       WorkflowRetrier
+	retryStore    RetryStore
+}
+
+// New returns a reconciler reading workflows of the namespace.
+func New(client WorkflowClient, namespace string) *Reconciler {
+	return &Reconciler{
+		client:    client,
+		namespace: namespace,
+		kinds:     make(map[string]Processor),
+	}
+}
+
+// SetTimeouts sets how long an entity may stay in a status, by kind and status name.
+// An entity staying longer is moved to the status its kind takes when the workflow fails.
+// The time an entity has stayed in its status is measured by the clock.
+func (r *Reconciler) SetTimeouts(timeouts map[string]map[string]time.Duration, clock StatusClock) {
+	r.timeouts = timeouts
+	r.clock = clock
+}
+
+// SetRetry enables retrying failed workflows of the kinds in policies.
+func (r *Reconciler) SetRetry(policies map[string]RetryPolicy, retrier WorkflowRetrier, store RetryStore) {
+	r.retryPolicies = policies
+	r.retrier = retrier
+	r.retryStore = store
+}
+
+// Observe adds an observer of status transitions.
+func (r *Reconciler) Observe(o Observer) {
+	r.observers = append(r.observers, o)
+}
+
+// Notify passes a transition to the observers.
+// It is also used for transitions made outside of the reconciler.
+func (r *Reconciler) Notify(ctx context.Context, e Event) {
+	metrics.StatusTransitions.WithLabelValues(e.Kind, e.OldStatus, e.NewStatus).Inc()
+	for _, o := range r.observers {
+		o(ctx, e)
+	}
+}
+
+// Register adds a kind to the reconciler.
+func Register[S Status](r *Reconciler, k *Kind[S]) {
+	k.r = r
+	r.kinds[k.name] = k
+}
+
+// Reconcile runs the reconciliation of a registered kind.
+func (r *Reconciler) Reconcile(ctx context.Context, kind string) error {
+	p, ok := r.kinds[kind]
+	if !ok {
+		return fmt.Errorf("unknown kind %s", kind)
+	}
+	return p.Reconcile(ctx)
+}
+
+// Kind describes how entities of a kind are listed, updated and moved between statuses.
+type Kind[S Status] struct {
+	name    string
+	table   map[S]map[string]S
+	onEnter map[S]func(id string) error
+	list    func() ([]Entity[S], error)
+	update  func(id string, status S, statusDesc string, workflowId string) error
+	r       *Reconciler
+}
+
+// NewKind builds a kind from its transition table.
+func NewKind[S Status](
+	name string,
+	transitions []Transition[S],
+	list func() ([]Entity[S], error),
+	update func(id string, status S, statusDesc string, workflowId string) error,
+) *Kind[S] {
+	k := &Kind[S]{
+		name:    name,
+		table:   make(map[S]map[string]S),
+		onEnter: make(map[S]func(id string) error),
+		list:    list,
+		update:  update,
+	}
+	for _, t := range transitions {
+		if _, ok := k.table[t.From]; !ok {
+			k.table[t.From] = make(map[string]S)
+		}
+		k.table[t.From][t.Phase] = t.To
+	}
+	return k
+}
+
+// OnEnter registers a side effect which runs after an entity has moved into the status.
+func (k *Kind[S]) OnEnter(status S, fn func(id string) error) *Kind[S] {
+	k.onEnter[status] = fn
+	return k
+}
+
+func (k *Kind[S]) Name() string {
+	return k.name
+}
+
+// Next returns the status an entity in from status moves to on the phase.
+func (k *Kind[S]) Next(from S, phase string) (S, bool) {
+	to, ok := k.table[from][phase]
+	return to, ok
+}
+
+func (k *Kind[S]) Reconcile(ctx context.Context) error {
+	entities, err := k.list()
+	if err != nil {
+		return err
+	}
+	metrics.EntityStatus.DeletePartialMatch(prometheus.Labels{"kind": k.name})
+	for _, entity := range entities {
+		metrics.EntityStatus.WithLabelValues(k.name, entity.Status.String()).Inc()
+	}
+	if len(entities) == 0 {
+		return nil
+	}
+	log.Info(ctx, fmt.Sprintf("[%s] entities : ", k.name), entities)
+
+	for _, entity := range entities {
+		if entity.WorkflowId == "" {
+			if timeout, stuck := k.stuck(ctx, entity); stuck {
+				k.fail(ctx, entity, fmt.Sprintf("no argo workflow has been started for %s", timeout))
+			}
+			continue
+		}
+
+		wf, err := k.r.client.GetWorkflow(ctx, k.r.namespace, entity.WorkflowId)
+		if err != nil {
+			if workflow.IsNotFound(err) {
+				k.fail(ctx, entity, fmt.Sprintf("argo workflow %s is not found", entity.WorkflowId))
+				continue
+			}
+			log.Error(ctx, "failed to get argo workflow. err : ", err)
+			if timeout, stuck := k.stuck(ctx, entity); stuck {
+				k.fail(ctx, entity, fmt.Sprintf("argo workflow %s could not be read for %s. err : %s", entity.WorkflowId, timeout, err))
+			}
+			continue
+		}
+
+		newMessage := fmt.Sprintf("(%s) %s", wf.Status.Progress, wf.Status.Message)
+		log.Debug(ctx, fmt.Sprintf("status [%s], newMessage [%s], phase [%s]", entity.Status, newMessage, wf.Status.Phase))
+
+		newStatus, ok := k.next(ctx, entity, wf.Status.Phase)
+		if !ok {
+			continue
+		}
+
+		if wf.Status.Phase == PhaseFailed || wf.Status.Phase == PhaseError {
+			message, retrying := k.retry(ctx, entity, newMessage)
+			if retrying {
+				if entity.StatusDesc != message {
+					k.transition(ctx, entity, entity.Status, message)
+				}
+				continue
+			}
+			newMessage = message
+		}
+
+		if newStatus == entity.Status {
+			if timeout, stuck := k.stuck(ctx, entity); stuck {
+				k.fail(ctx, entity, fmt.Sprintf("timed out in %s after %s. %s", entity.Status, timeout, newMessage))
+				continue
+			}
+		}
+
+		if entity.Status == newStatus && entity.StatusDesc == newMessage {
+			continue
+		}
+		k.transition(ctx, entity, newStatus, newMessage)
+	}
+	return nil
+}
+
+// transition updates the entity and runs the side effect of the new status.
+func (k *Kind[S]) transition(ctx context.Context, entity Entity[S], newStatus S, newMessage string) {
+	log.Debug(ctx, fmt.Sprintf("update status!! %s [%s], newStatus [%s], newMessage [%s]", k.name, entity.ID, newStatus, newMessage))
+	if err := k.update(entity.ID, newStatus, newMessage, entity.WorkflowId); err != nil {
+		log.Error(ctx, fmt.Sprintf("Failed to update %s status err : ", k.name), err)
+		return
+	}
+
+	if entity.Status == newStatus {
+		return
+	}
+	k.r.Notify(ctx, Event{
+		Kind:           k.name,
+		EntityId:       entity.ID,
+		OrganizationId: entity.OrganizationId,
+		WorkflowId:     entity.WorkflowId,
+		OldStatus:      entity.Status.String(),
+		NewStatus:      newStatus.String(),
+		Message:        newMessage,
+	})
+	if fn, ok := k.onEnter[newStatus]; ok {
+		if err := fn(entity.ID); err != nil {
+			log.Error(ctx, fmt.Sprintf("Failed to run side effect of %s [%s] for %s err : ", k.name, newStatus, entity.ID), err)
+		}
+	}
+}
+
+// retry retries the failed workflow of the entity according to the retry policy of the kind.
+// It reports whether the entity should stay in its status, and the message to show meanwhile.
+func (k *Kind[S]) retry(ctx context.Context, entity Entity[S], message string) (string, bool) {
+	policy, ok := k.r.retryPolicies[k.name]
+	if !ok || policy.MaxAttempts <= 0 {
+		return message, false
+	}
+
+	attempts, lastAttempt, err := k.r.retryStore.Attempts(k.name, entity.ID, entity.WorkflowId)
+	if err != nil {
+		log.Error(ctx, "failed to get workflow retries. err : ", err)
+		return message, false
+	}
+	if attempts >= policy.MaxAttempts {
+		log.Info(ctx, fmt.Sprintf("%s [%s] : retries of argo workflow %s are exhausted", k.name, entity.ID, entity.WorkflowId))
+		return fmt.Sprintf("failed after %d retries. %s", attempts, message), false
+	}
+
+	if attempts > 0 && time.Since(lastAttempt) < policy.Backoff<<(attempts-1) {
+		return fmt.Sprintf("waiting to retry argo workflow (attempt %d/%d). %s", attempts+1, policy.MaxAttempts, message), true
+	}
+
+	// count the attempt first, so that a retry which keeps failing cannot run forever
+	if err := k.r.retryStore.AddAttempt(k.name, entity.ID, entity.WorkflowId); err != nil {
+		log.Error(ctx, "failed to add workflow retry. err : ", err)
+		return message, false
+	}
+	log.Info(ctx, fmt.Sprintf("%s [%s] : retry argo workflow %s (attempt %d/%d)", k.name, entity.ID, entity.WorkflowId, attempts+1, policy.MaxAttempts))
+	if err := k.r.retrier.RetryWorkflow(ctx, k.r.namespace, entity.WorkflowId); err != nil {
+		log.Error(ctx, "failed to retry argo workflow. err : ", err)
+	}
+	return fmt.Sprintf("retrying argo workflow (attempt %d/%d). %s", attempts+1, policy.MaxAttempts, message), true
+}
+
+// fail moves the entity to the status its workflow would have ended in on failure.
+func (k *Kind[S]) fail(ctx context.Context, entity Entity[S], message string) {
+	errorStatus, ok := k.Next(entity.Status, PhaseFailed)
+	if !ok {
+		log.Error(ctx, fmt.Sprintf("no error status for %s [%s] in %s", k.name, entity.ID, entity.Status))
+		return
+	}
+	log.Warn(ctx, fmt.Sprintf("%s [%s] : %s", k.name, entity.ID, message))
+	k.transition(ctx, entity, errorStatus, message)
+}
+
+// stuck reports whether the entity has stayed in its status longer than the timeout of the status.
+func (k *Kind[S]) stuck(ctx context.Context, entity Entity[S]) (time.Duration, bool) {
+	timeout, ok := k.r.timeouts[k.name][entity.Status.String()]
+	if !ok || timeout <= 0 || k.r.clock == nil {
+		return timeout, false
+	}
+	enteredAt, err := k.r.clock.EnteredAt(k.name, entity.ID, entity.Status.String())
+	if err != nil {
+		log.Error(ctx, "failed to get the time the status was entered. err : ", err)
+		return timeout, false
+	}
+	return timeout, time.Since(enteredAt) > timeout
+}
+
+// next resolves the phase of the workflow, including the derived paused phase, into a status.
+func (k *Kind[S]) next(ctx context.Context, entity Entity[S], phase string) (S, bool) {
+	if phase == PhaseRunning {
+		if to, ok := k.Next(entity.Status, PhasePaused); ok {
+			paused, err := k.r.client.IsPausedWorkflow(ctx, k.r.namespace, entity.WorkflowId)
+			if err == nil && paused {
+				return to, true
+			}
+		}
+	}
+	return k.Next(entity.Status, phase)
+}
@@ -0,0 +1,357 @@
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	argo "github.com/openinfradev/tks-api/pkg/argo-client"
+	"github.com/openinfradev/tks-api/pkg/domain"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testNamespace  = "argo"
+	testWorkflowId = "create-tks-usercluster-abcde"
+)
+
+type fakeWorkflowClient struct {
+	workflow *argo.Workflow
+	err      error
+	paused   bool
+}
+
+func (c *fakeWorkflowClient) GetWorkflow(ctx context.Context, namespace string, workflowName string) (*argo.Workflow, error) {
+	return c.workflow, c.err
+}
+
+func (c *fakeWorkflowClient) IsPausedWorkflow(ctx context.Context, namespace string, workflowName string) (bool, error) {
+	return c.paused, nil
+}
+
+type fakeRetryStore struct {
+	attempts    int
+	lastAttempt time.Time
+	added       int
+}
+
+func (s *fakeRetryStore) Attempts(kind string, entityId string, workflowId string) (int, time.Time, error) {
+	return s.attempts, s.lastAttempt, nil
+}
+
+func (s *fakeRetryStore) AddAttempt(kind string, entityId string, workflowId string) error {
+	s.added++
+	return nil
+}
+
+// fakeStatusClock keeps when each entity has entered its status. An unknown entity enters it now.
+type fakeStatusClock map[string]time.Time
+
+func (c fakeStatusClock) EnteredAt(kind string, entityId string, status string) (time.Time, error) {
+	if enteredAt, ok := c[entityId]; ok {
+		return enteredAt, nil
+	}
+	return time.Now(), nil
+}
+
+type fakeRetrier struct {
+	retried []string
+}
+
+func (r *fakeRetrier) RetryWorkflow(ctx context.Context, namespace string, workflowName string) error {
+	r.retried = append(r.retried, workflowName)
+	return nil
+}
+
+type update struct {
+	status     domain.ClusterStatus
+	statusDesc string
+}
+
+func workflowIn(phase string) *argo.Workflow {
+	wf := &argo.Workflow{}
+	wf.Status.Phase = phase
+	wf.Status.Progress = "1/2"
+	wf.Status.Message = "message"
+	return wf
+}
+
+func TestKindReconcile(t *testing.T) {
+	testCases := []struct {
+		name      string
+		entity    Entity[domain.ClusterStatus]
+		enteredAt time.Time
+		client    *fakeWorkflowClient
+		timeouts  map[string]map[string]time.Duration
+		policy    *RetryPolicy
+		store     *fakeRetryStore
+		// expected updates, events and retried workflows
+		updates []update
+		events  []string
+		retried int
+	}{
+		{
+			name:    "running workflow updates the message",
+			entity:  Entity[domain.ClusterStatus]{Status: domain.ClusterStatus_INSTALLING},
+			client:  &fakeWorkflowClient{workflow: workflowIn(PhaseRunning)},
+			updates: []update{{domain.ClusterStatus_INSTALLING, "(1/2) message"}},
+		},
+		{
+			name:   "unchanged message updates nothing",
+			entity: Entity[domain.ClusterStatus]{Status: domain.ClusterStatus_INSTALLING, StatusDesc: "(1/2) message"},
+			client: &fakeWorkflowClient{workflow: workflowIn(PhaseRunning)},
+		},
+		{
+			name:    "succeeded",
+			entity:  Entity[domain.ClusterStatus]{Status: domain.ClusterStatus_INSTALLING},
+			client:  &fakeWorkflowClient{workflow: workflowIn(PhaseSucceeded)},
+			updates: []update{{domain.ClusterStatus_RUNNING, "(1/2) message"}},
+			events:  []string{"INSTALLING>RUNNING"},
+		},
+		{
+			name:    "paused",
+			entity:  Entity[domain.ClusterStatus]{Status: domain.ClusterStatus_INSTALLING},
+			client:  &fakeWorkflowClient{workflow: workflowIn(PhaseRunning), paused: true},
+			updates: []update{{domain.ClusterStatus_STOPPED, "(1/2) message"}},
+			events:  []string{"INSTALLING>STOPPED"},
+		},
+		{
+			name:    "paused without a paused transition keeps running",
+			entity:  Entity[domain.ClusterStatus]{Status: domain.ClusterStatus_DELETING},
+			client:  &fakeWorkflowClient{workflow: workflowIn(PhaseRunning), paused: true},
+			updates: []update{{domain.ClusterStatus_DELETING, "(1/2) message"}},
+		},
+		{
+			name:    "stopped",
+			entity:  Entity[domain.ClusterStatus]{Status: domain.ClusterStatus_INSTALLING},
+			client:  &fakeWorkflowClient{workflow: workflowIn(PhaseStopped)},
+			updates: []update{{domain.ClusterStatus_STOPPED, "(1/2) message"}},
+			events:  []string{"INSTALLING>STOPPED"},
+		},
+		{
+			name:    "stopped without a stopped status",
+			entity:  Entity[domain.ClusterStatus]{Status: domain.ClusterStatus_DELETING},
+			client:  &fakeWorkflowClient{workflow: workflowIn(PhaseStopped)},
+			updates: []update{{domain.ClusterStatus_DELETE_ERROR, "(1/2) message"}},
+			events:  []string{"DELETING>DELETE_ERROR"},
+		},
+		{
+			name:   "phase without a transition",
+			entity: Entity[domain.ClusterStatus]{Status: domain.ClusterStatus_DELETING},
+			client: &fakeWorkflowClient{workflow: workflowIn("Pending")},
+		},
+		{
+			name:    "failed",
+			entity:  Entity[domain.ClusterStatus]{Status: domain.ClusterStatus_INSTALLING},
+			client:  &fakeWorkflowClient{workflow: workflowIn(PhaseFailed)},
+			updates: []update{{domain.ClusterStatus_INSTALL_ERROR, "(1/2) message"}},
+			events:  []string{"INSTALLING>INSTALL_ERROR"},
+		},
+		{
+			name:   "workflow not found",
+			entity: Entity[domain.ClusterStatus]{Status: domain.ClusterStatus_DELETING},
+			client: &fakeWorkflowClient{err: fmt.Errorf("Invalid http status. return code: 404")},
+			updates: []update{{domain.ClusterStatus_DELETE_ERROR,
+				fmt.Sprintf("argo workflow %s is not found", testWorkflowId)}},
+			events: []string{"DELETING>DELETE_ERROR"},
+		},
+		{
+			name:      "unreadable workflow within the timeout",
+			entity:    Entity[domain.ClusterStatus]{Status: domain.ClusterStatus_INSTALLING},
+			enteredAt: time.Now().Add(-time.Hour),
+			client:    &fakeWorkflowClient{err: fmt.Errorf("Invalid http status. return code: 500")},
+			timeouts: map[string]map[string]time.Duration{
+				KindCluster: {"INSTALLING": 2 * time.Hour},
+			},
+		},
+		{
+			name:      "unreadable workflow after the timeout",
+			entity:    Entity[domain.ClusterStatus]{Status: domain.ClusterStatus_INSTALLING},
+			enteredAt: time.Now().Add(-3 * time.Hour),
+			client:    &fakeWorkflowClient{err: fmt.Errorf("Invalid http status. return code: 500")},
+			timeouts: map[string]map[string]time.Duration{
+				KindCluster: {"INSTALLING": 2 * time.Hour},
+			},
+			updates: []update{{domain.ClusterStatus_INSTALL_ERROR,
+				fmt.Sprintf("argo workflow %s could not be read for 2h0m0s. err : Invalid http status. return code: 500", testWorkflowId)}},
+			events: []string{"INSTALLING>INSTALL_ERROR"},
+		},
+		{
+			name:      "stuck in a running workflow",
+			entity:    Entity[domain.ClusterStatus]{Status: domain.ClusterStatus_INSTALLING},
+			enteredAt: time.Now().Add(-3 * time.Hour),
+			client:    &fakeWorkflowClient{workflow: workflowIn(PhaseRunning)},
+			timeouts: map[string]map[string]time.Duration{
+				KindCluster: {"INSTALLING": 2 * time.Hour},
+			},
+			updates: []update{{domain.ClusterStatus_INSTALL_ERROR, "timed out in INSTALLING after 2h0m0s. (1/2) message"}},
+			events:  []string{"INSTALLING>INSTALL_ERROR"},
+		},
+		{
+			name:      "timeout of another status",
+			entity:    Entity[domain.ClusterStatus]{Status: domain.ClusterStatus_INSTALLING},
+			enteredAt: time.Now().Add(-3 * time.Hour),
+			client:    &fakeWorkflowClient{workflow: workflowIn(PhaseRunning)},
+			timeouts: map[string]map[string]time.Duration{
+				KindCluster: {"DELETING": 2 * time.Hour},
+			},
+			updates: []update{{domain.ClusterStatus_INSTALLING, "(1/2) message"}},
+		},
+		{
+			name:    "retry a failed workflow",
+			entity:  Entity[domain.ClusterStatus]{Status: domain.ClusterStatus_INSTALLING},
+			client:  &fakeWorkflowClient{workflow: workflowIn(PhaseFailed)},
+			policy:  &RetryPolicy{MaxAttempts: 2, Backoff: time.Minute},
+			store:   &fakeRetryStore{},
+			updates: []update{{domain.ClusterStatus_INSTALLING, "retrying argo workflow (attempt 1/2). (1/2) message"}},
+			retried: 1,
+		},
+		{
+			name:    "wait for the backoff",
+			entity:  Entity[domain.ClusterStatus]{Status: domain.ClusterStatus_INSTALLING},
+			client:  &fakeWorkflowClient{workflow: workflowIn(PhaseFailed)},
+			policy:  &RetryPolicy{MaxAttempts: 3, Backoff: time.Minute},
+			store:   &fakeRetryStore{attempts: 2, lastAttempt: time.Now().Add(-90 * time.Second)},
+			updates: []update{{domain.ClusterStatus_INSTALLING, "waiting to retry argo workflow (attempt 3/3). (1/2) message"}},
+		},
+		{
+			name:    "retry after the backoff",
+			entity:  Entity[domain.ClusterStatus]{Status: domain.ClusterStatus_INSTALLING},
+			client:  &fakeWorkflowClient{workflow: workflowIn(PhaseFailed)},
+			policy:  &RetryPolicy{MaxAttempts: 3, Backoff: time.Minute},
+			store:   &fakeRetryStore{attempts: 2, lastAttempt: time.Now().Add(-3 * time.Minute)},
+			updates: []update{{domain.ClusterStatus_INSTALLING, "retrying argo workflow (attempt 3/3). (1/2) message"}},
+			retried: 1,
+		},
+		{
+			name:    "retries exhausted",
+			entity:  Entity[domain.ClusterStatus]{Status: domain.ClusterStatus_INSTALLING},
+			client:  &fakeWorkflowClient{workflow: workflowIn(PhaseError)},
+			policy:  &RetryPolicy{MaxAttempts: 2, Backoff: time.Minute},
+			store:   &fakeRetryStore{attempts: 2, lastAttempt: time.Now().Add(-time.Hour)},
+			updates: []update{{domain.ClusterStatus_INSTALL_ERROR, "failed after 2 retries. (1/2) message"}},
+			events:  []string{"INSTALLING>INSTALL_ERROR"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.entity.ID = "cluster-id"
+			tc.entity.WorkflowId = testWorkflowId
+
+			var updates []update
+			kind := NewKind(KindCluster, ClusterTransitions,
+				func() ([]Entity[domain.ClusterStatus], error) {
+					return []Entity[domain.ClusterStatus]{tc.entity}, nil
+				},
+				func(id string, status domain.ClusterStatus, statusDesc string, workflowId string) error {
+					require.Equal(t, tc.entity.ID, id)
+					require.Equal(t, testWorkflowId, workflowId)
+					updates = append(updates, update{status, statusDesc})
+					return nil
+				})
+
+			r := New(tc.client, testNamespace)
+			clock := fakeStatusClock{}
+			if !tc.enteredAt.IsZero() {
+				clock[tc.entity.ID] = tc.enteredAt
+			}
+			r.SetTimeouts(tc.timeouts, clock)
+			retrier := &fakeRetrier{}
+			if tc.policy != nil {
+				r.SetRetry(map[string]RetryPolicy{KindCluster: *tc.policy}, retrier, tc.store)
+			}
+			var events []string
+			r.Observe(func(ctx context.Context, e Event) {
+				require.Equal(t, KindCluster, e.Kind)
+				events = append(events, e.OldStatus+">"+e.NewStatus)
+			})
+			Register(r, kind)
+
+			require.NoError(t, r.Reconcile(context.Background(), KindCluster))
+			require.Equal(t, tc.updates, updates)
+			require.Equal(t, tc.events, events)
+			require.Len(t, retrier.retried, tc.retried)
+			if tc.store != nil {
+				require.Equal(t, tc.retried, tc.store.added)
+			}
+		})
+	}
+}
+
+func TestKindReconcileStopped(t *testing.T) {
+	testCases := []struct {
+		name   string
+		status domain.AppGroupStatus
+		want   domain.AppGroupStatus
+	}{
+		{"installing", domain.AppGroupStatus_INSTALLING, domain.AppGroupStatus_INSTALL_ERROR},
+		{"deleting", domain.AppGroupStatus_DELETING, domain.AppGroupStatus_DELETE_ERROR},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var updated []domain.AppGroupStatus
+			kind := NewKind(KindAppGroup, AppGroupTransitions,
+				func() ([]Entity[domain.AppGroupStatus], error) {
+					return []Entity[domain.AppGroupStatus]{{ID: "appgroup-id", WorkflowId: testWorkflowId, Status: tc.status}}, nil
+				},
+				func(id string, status domain.AppGroupStatus, statusDesc string, workflowId string) error {
+					updated = append(updated, status)
+					return nil
+				})
+
+			r := New(&fakeWorkflowClient{workflow: workflowIn(PhaseStopped)}, testNamespace)
+			Register(r, kind)
+
+			require.NoError(t, r.Reconcile(context.Background(), KindAppGroup))
+			require.Equal(t, []domain.AppGroupStatus{tc.want}, updated)
+		})
+	}
+}
+
+func TestKindReconcileWithoutWorkflow(t *testing.T) {
+	entities := []Entity[domain.ClusterStatus]{
+		{ID: "stuck", Status: domain.ClusterStatus_BOOTSTRAPPING},
+		{ID: "recent", Status: domain.ClusterStatus_BOOTSTRAPPING},
+	}
+	var updated []string
+	kind := NewKind(KindCluster, ClusterTransitions,
+		func() ([]Entity[domain.ClusterStatus], error) { return entities, nil },
+		func(id string, status domain.ClusterStatus, statusDesc string, workflowId string) error {
+			require.Equal(t, domain.ClusterStatus_BOOTSTRAP_ERROR, status)
+			require.Equal(t, "no argo workflow has been started for 2h0m0s", statusDesc)
+			updated = append(updated, id)
+			return nil
+		})
+
+	r := New(&fakeWorkflowClient{err: fmt.Errorf("must not be called")}, testNamespace)
+	r.SetTimeouts(map[string]map[string]time.Duration{KindCluster: {"BOOTSTRAPPING": 2 * time.Hour}},
+		fakeStatusClock{"stuck": time.Now().Add(-3 * time.Hour), "recent": time.Now()})
+	Register(r, kind)
+
+	require.NoError(t, r.Reconcile(context.Background(), KindCluster))
+	require.Equal(t, []string{"stuck"}, updated)
+}
+
+func TestKindOnEnter(t *testing.T) {
+	entered := []string{}
+	kind := NewKind(KindCluster, ClusterTransitions,
+		func() ([]Entity[domain.ClusterStatus], error) {
+			return []Entity[domain.ClusterStatus]{
+				{ID: "succeeded", WorkflowId: testWorkflowId, Status: domain.ClusterStatus_INSTALLING},
+			}, nil
+		},
+		func(id string, status domain.ClusterStatus, statusDesc string, workflowId string) error { return nil }).
+		OnEnter(domain.ClusterStatus_RUNNING, func(id string) error {
+			entered = append(entered, id)
+			return nil
+		})
+
+	r := New(&fakeWorkflowClient{workflow: workflowIn(PhaseSucceeded)}, testNamespace)
+	Register(r, kind)
+
+	require.NoError(t, r.Reconcile(context.Background(), KindCluster))
+	require.Equal(t, []string{"succeeded"}, entered)
+}
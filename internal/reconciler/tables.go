@@ -0,0 +1,77 @@
+package reconciler
+
+import (
+	"github.com/openinfradev/tks-api/pkg/domain"
+)
+
+// Kind names
+const (
+	KindCluster      = "cluster"
+	KindAppGroup     = "appgroup"
+	KindOrganization = "organization"
+	KindCloudAccount = "cloudaccount"
+)
+
+// A stopped workflow leaves the entity in an error status, except where clusters have the STOPPED status.
+var ClusterTransitions = []Transition[domain.ClusterStatus]{
+	{domain.ClusterStatus_INSTALLING, PhaseRunning, domain.ClusterStatus_INSTALLING},
+	{domain.ClusterStatus_INSTALLING, PhasePaused, domain.ClusterStatus_STOPPED},
+	{domain.ClusterStatus_INSTALLING, PhaseStopped, domain.ClusterStatus_STOPPED},
+	{domain.ClusterStatus_INSTALLING, PhaseSucceeded, domain.ClusterStatus_RUNNING},
+	{domain.ClusterStatus_INSTALLING, PhaseFailed, domain.ClusterStatus_INSTALL_ERROR},
+	{domain.ClusterStatus_INSTALLING, PhaseError, domain.ClusterStatus_INSTALL_ERROR},
+
+	{domain.ClusterStatus_DELETING, PhaseRunning, domain.ClusterStatus_DELETING},
+	{domain.ClusterStatus_DELETING, PhaseStopped, domain.ClusterStatus_DELETE_ERROR},
+	{domain.ClusterStatus_DELETING, PhaseSucceeded, domain.ClusterStatus_DELETED},
+	{domain.ClusterStatus_DELETING, PhaseFailed, domain.ClusterStatus_DELETE_ERROR},
+	{domain.ClusterStatus_DELETING, PhaseError, domain.ClusterStatus_DELETE_ERROR},
+
+	{domain.ClusterStatus_BOOTSTRAPPING, PhaseRunning, domain.ClusterStatus_BOOTSTRAPPING},
+	{domain.ClusterStatus_BOOTSTRAPPING, PhaseStopped, domain.ClusterStatus_BOOTSTRAP_ERROR},
+	{domain.ClusterStatus_BOOTSTRAPPING, PhaseSucceeded, domain.ClusterStatus_BOOTSTRAPPED},
+	{domain.ClusterStatus_BOOTSTRAPPING, PhaseFailed, domain.ClusterStatus_BOOTSTRAP_ERROR},
+	{domain.ClusterStatus_BOOTSTRAPPING, PhaseError, domain.ClusterStatus_BOOTSTRAP_ERROR},
+}
+
+var AppGroupTransitions = []Transition[domain.AppGroupStatus]{
+	{domain.AppGroupStatus_INSTALLING, PhaseRunning, domain.AppGroupStatus_INSTALLING},
+	{domain.AppGroupStatus_INSTALLING, PhaseStopped, domain.AppGroupStatus_INSTALL_ERROR},
+	{domain.AppGroupStatus_INSTALLING, PhaseSucceeded, domain.AppGroupStatus_RUNNING},
+	{domain.AppGroupStatus_INSTALLING, PhaseFailed, domain.AppGroupStatus_INSTALL_ERROR},
+	{domain.AppGroupStatus_INSTALLING, PhaseError, domain.AppGroupStatus_INSTALL_ERROR},
+
+	{domain.AppGroupStatus_DELETING, PhaseRunning, domain.AppGroupStatus_DELETING},
+	{domain.AppGroupStatus_DELETING, PhaseStopped, domain.AppGroupStatus_DELETE_ERROR},
+	{domain.AppGroupStatus_DELETING, PhaseSucceeded, domain.AppGroupStatus_DELETED},
+	{domain.AppGroupStatus_DELETING, PhaseFailed, domain.AppGroupStatus_DELETE_ERROR},
+	{domain.AppGroupStatus_DELETING, PhaseError, domain.AppGroupStatus_DELETE_ERROR},
+}
+
+var OrganizationTransitions = []Transition[domain.OrganizationStatus]{
+	{domain.OrganizationStatus_CREATING, PhaseRunning, domain.OrganizationStatus_CREATING},
+	{domain.OrganizationStatus_CREATING, PhaseStopped, domain.OrganizationStatus_ERROR},
+	{domain.OrganizationStatus_CREATING, PhaseSucceeded, domain.OrganizationStatus_CREATED},
+	{domain.OrganizationStatus_CREATING, PhaseFailed, domain.OrganizationStatus_ERROR},
+	{domain.OrganizationStatus_CREATING, PhaseError, domain.OrganizationStatus_ERROR},
+
+	{domain.OrganizationStatus_DELETING, PhaseRunning, domain.OrganizationStatus_DELETING},
+	{domain.OrganizationStatus_DELETING, PhaseStopped, domain.OrganizationStatus_ERROR},
+	{domain.OrganizationStatus_DELETING, PhaseSucceeded, domain.OrganizationStatus_DELETED},
+	{domain.OrganizationStatus_DELETING, PhaseFailed, domain.OrganizationStatus_ERROR},
+	{domain.OrganizationStatus_DELETING, PhaseError, domain.OrganizationStatus_ERROR},
+}
+
+var CloudAccountTransitions = []Transition[domain.CloudAccountStatus]{
+	{domain.CloudAccountStatus_CREATING, PhaseRunning, domain.CloudAccountStatus_CREATING},
+	{domain.CloudAccountStatus_CREATING, PhaseStopped, domain.CloudAccountStatus_CREATE_ERROR},
+	{domain.CloudAccountStatus_CREATING, PhaseSucceeded, domain.CloudAccountStatus_CREATED},
+	{domain.CloudAccountStatus_CREATING, PhaseFailed, domain.CloudAccountStatus_CREATE_ERROR},
+	{domain.CloudAccountStatus_CREATING, PhaseError, domain.CloudAccountStatus_CREATE_ERROR},
+
+	{domain.CloudAccountStatus_DELETING, PhaseRunning, domain.CloudAccountStatus_DELETING},
+	{domain.CloudAccountStatus_DELETING, PhaseStopped, domain.CloudAccountStatus_DELETE_ERROR},
+	{domain.CloudAccountStatus_DELETING, PhaseSucceeded, domain.CloudAccountStatus_DELETED},
+	{domain.CloudAccountStatus_DELETING, PhaseFailed, domain.CloudAccountStatus_DELETE_ERROR},
+	{domain.CloudAccountStatus_DELETING, PhaseError, domain.CloudAccountStatus_DELETE_ERROR},
+}
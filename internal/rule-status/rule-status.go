@@ -0,0 +1,125 @@
+package ruleStatus
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Outcomes of writing a rule to thanos-ruler
+const (
+	StatusApplied = "APPLIED"
+	StatusInvalid = "INVALID"
+	StatusSkipped = "SKIPPED"
+	// StatusNotLoaded is an applied rule which thanos-ruler has not loaded or fails to evaluate.
+	StatusNotLoaded = "NOT_LOADED"
+)
+
+// RuleStatus is the outcome of the last time tks-batch wrote the rules of an organization.
+type RuleStatus struct {
+	RuleId         string `gorm:"primarykey"`
+	OrganizationId string `gorm:"index"`
+	Status         string
+	// Reason is why the rule is invalid or skipped.
+	Reason string
+	// ContentHash is the hash of the rules generated from the rule.
+	ContentHash string
+	// AppliedAt is when the content has been written to the cluster.
+	AppliedAt *time.Time
+	// VerifiedAt is when thanos-ruler has been checked to have loaded the rule.
+	VerifiedAt *time.Time
+	UpdatedAt  time.Time
+}
+
+// RuleStatusAccessor accesses rule statuses in DB.
+type RuleStatusAccessor struct {
+	db *gorm.DB
+}
+
+// New returns new accessor's ptr.
+func New(db *gorm.DB) *RuleStatusAccessor {
+	return &RuleStatusAccessor{
+		db: db,
+	}
+}
+
+// For Unittest
+func (x *RuleStatusAccessor) GetDb() *gorm.DB {
+	return x.db
+}
+
+// Migrate creates the table owned by tks-batch.
+func (x *RuleStatusAccessor) Migrate() error {
+	return x.db.AutoMigrate(&RuleStatus{})
+}
+
+// Save replaces the statuses of the rules of the organization.
+// AppliedAt of a status is kept if it is nil, and the statuses of the other rules of the organization are deleted.
+func (x *RuleStatusAccessor) Save(organizationId string, statuses []RuleStatus) error {
+	return x.db.Transaction(func(tx *gorm.DB) error {
+		ruleIds := make([]string, len(statuses))
+		for i := range statuses {
+			statuses[i].OrganizationId = organizationId
+			ruleIds[i] = statuses[i].RuleId
+		}
+
+		deleted := tx.Where("organization_id = ?", organizationId)
+		if len(ruleIds) > 0 {
+			deleted = deleted.Where("rule_id NOT IN ?", ruleIds)
+		}
+		if res := deleted.Delete(&RuleStatus{}); res.Error != nil {
+			return res.Error
+		}
+		if len(statuses) == 0 {
+			return nil
+		}
+
+		res := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "rule_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"organization_id": gorm.Expr("excluded.organization_id"),
+				"status":          gorm.Expr("excluded.status"),
+				"reason":          gorm.Expr("excluded.reason"),
+				"content_hash":    gorm.Expr("excluded.content_hash"),
+				"applied_at":      gorm.Expr("COALESCE(excluded.applied_at, rule_statuses.applied_at)"),
+				"updated_at":      gorm.Expr("excluded.updated_at"),
+			}),
+		}).Create(&statuses)
+		return res.Error
+	})
+}
+
+// UpdateVerification records whether thanos-ruler has loaded the applied rule.
+// It does not touch the rules which are invalid or skipped.
+func (x *RuleStatusAccessor) UpdateVerification(ruleId string, status string, reason string) error {
+	now := time.Now()
+	res := x.db.Model(&RuleStatus{}).
+		Where("rule_id = ? AND status IN ?", ruleId, []string{StatusApplied, StatusNotLoaded}).
+		Updates(map[string]interface{}{
+			"status":      status,
+			"reason":      reason,
+			"verified_at": now,
+			"updated_at":  now,
+		})
+
+	if res.Error != nil {
+		return res.Error
+	}
+	return nil
+}
+
+// GetByOrganization returns the statuses of the rules of the organization.
+func (x *RuleStatusAccessor) GetByOrganization(organizationId string) ([]RuleStatus, error) {
+	var statuses []RuleStatus
+
+	res := x.db.
+		Where("organization_id = ?", organizationId).
+		Order("rule_id").
+		Find(&statuses)
+
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return statuses, nil
+}
@@ -0,0 +1,162 @@
+package rulerReload
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RulerReload is the bookkeeping of the rules of an organization written to the ConfigMap and reloaded in thanos-ruler.
+// The rules are to be reloaded while ReloadedHash differs from AppliedHash.
+type RulerReload struct {
+	OrganizationId string `gorm:"primarykey"`
+	// AppliedHash is the hash of the content which has been written to the ConfigMap last.
+	AppliedHash string
+	AppliedAt   time.Time
+	// ReloadedHash is the hash of the content which thanos-ruler has loaded last.
+	ReloadedHash string
+	ReloadedAt   *time.Time
+	// Attempts is the number of failed reloads of AppliedHash.
+	Attempts      int
+	LastError     string
+	NextAttemptAt *time.Time
+	// ReloadRequestedAt is when thanos-ruler has been requested to reload AppliedHash, in the attempt of ReloadRequestedAttempt.
+	// It is nil until the first request of AppliedHash.
+	ReloadRequestedAt      *time.Time
+	ReloadRequestedAttempt int
+	UpdatedAt              time.Time
+}
+
+// ReloadRequested tells whether thanos-ruler has been requested to reload AppliedHash in the current attempt.
+func (r RulerReload) ReloadRequested() bool {
+	return r.ReloadRequestedAt != nil && r.ReloadRequestedAttempt == r.Attempts
+}
+
+// RulerReloadAccessor accesses reload bookkeeping in DB.
+type RulerReloadAccessor struct {
+	db *gorm.DB
+}
+
+// New returns new accessor's ptr.
+func New(db *gorm.DB) *RulerReloadAccessor {
+	return &RulerReloadAccessor{
+		db: db,
+	}
+}
+
+// For Unittest
+func (x *RulerReloadAccessor) GetDb() *gorm.DB {
+	return x.db
+}
+
+// Migrate creates the table owned by tks-batch.
+func (x *RulerReloadAccessor) Migrate() error {
+	return x.db.AutoMigrate(&RulerReload{})
+}
+
+// SetApplied records the hash of the content written to the ConfigMap.
+// Nothing changes if the hash is already recorded, so that it is reloaded only once.
+func (x *RulerReloadAccessor) SetApplied(organizationId string, hash string) error {
+	now := time.Now()
+	reload := RulerReload{
+		OrganizationId: organizationId,
+		AppliedHash:    hash,
+		AppliedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	res := x.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "organization_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"applied_hash":             gorm.Expr("excluded.applied_hash"),
+			"applied_at":               gorm.Expr("excluded.applied_at"),
+			"attempts":                 0,
+			"last_error":               "",
+			"next_attempt_at":          nil,
+			"reload_requested_at":      nil,
+			"reload_requested_attempt": 0,
+			"updated_at":               gorm.Expr("excluded.updated_at"),
+		}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			gorm.Expr("ruler_reloads.applied_hash <> excluded.applied_hash"),
+		}},
+	}).Create(&reload)
+
+	if res.Error != nil {
+		return res.Error
+	}
+	return nil
+}
+
+// SetReloaded records that thanos-ruler has loaded the content of the hash.
+func (x *RulerReloadAccessor) SetReloaded(organizationId string, hash string) error {
+	now := time.Now()
+	res := x.db.Model(&RulerReload{}).
+		Where("organization_id = ?", organizationId).
+		Updates(map[string]interface{}{
+			"reloaded_hash":   hash,
+			"reloaded_at":     now,
+			"attempts":        0,
+			"last_error":      "",
+			"next_attempt_at": nil,
+			"updated_at":      now,
+		})
+
+	if res.Error != nil {
+		return res.Error
+	}
+	return nil
+}
+
+// SetReloadRequested records that thanos-ruler has been requested to reload the hash in the attempt.
+// It does nothing if another content has been applied in the meantime.
+func (x *RulerReloadAccessor) SetReloadRequested(organizationId string, hash string, attempt int) error {
+	now := time.Now()
+	res := x.db.Model(&RulerReload{}).
+		Where("organization_id = ? AND applied_hash = ?", organizationId, hash).
+		Updates(map[string]interface{}{
+			"reload_requested_at":      now,
+			"reload_requested_attempt": attempt,
+			"updated_at":               now,
+		})
+
+	if res.Error != nil {
+		return res.Error
+	}
+	return nil
+}
+
+// SetFailed records a failed reload of the hash and when to try it again.
+// It does nothing if another content has been applied in the meantime.
+func (x *RulerReloadAccessor) SetFailed(organizationId string, hash string, cause error, nextAttemptAt time.Time) error {
+	res := x.db.Model(&RulerReload{}).
+		Where("organization_id = ? AND applied_hash = ?", organizationId, hash).
+		Updates(map[string]interface{}{
+			"attempts":        gorm.Expr("attempts + 1"),
+			"last_error":      cause.Error(),
+			"next_attempt_at": nextAttemptAt,
+			"updated_at":      time.Now(),
+		})
+
+	if res.Error != nil {
+		return res.Error
+	}
+	return nil
+}
+
+// GetPending returns the organizations whose applied content has not been reloaded and is due to be tried.
+func (x *RulerReloadAccessor) GetPending() ([]RulerReload, error) {
+	var reloads []RulerReload
+
+	res := x.db.
+		Where("reloaded_hash <> applied_hash").
+		Where("next_attempt_at IS NULL OR next_attempt_at <= now()").
+		Order("applied_at").
+		Find(&reloads)
+
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return reloads, nil
+}
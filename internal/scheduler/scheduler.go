@@ -0,0 +1,161 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/openinfradev/tks-api/pkg/log"
+)
+
+// Job is a processor which runs periodically.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Timeout  time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// JobStatus is the result of the last run of a job.
+type JobStatus struct {
+	Name     string        `json:"name"`
+	Interval time.Duration `json:"interval"`
+	Timeout  time.Duration `json:"timeout"`
+	Running  bool          `json:"running"`
+	// StartedAt is when the scheduler has started the job.
+	StartedAt    time.Time     `json:"startedAt"`
+	LastRun      time.Time     `json:"lastRun"`
+	LastDuration time.Duration `json:"lastDuration"`
+	LastError    string        `json:"lastError"`
+	LastSuccess  time.Time     `json:"lastSuccess"`
+}
+
+type jobNameKey struct{}
+
+// JobName returns the name of the job running with ctx.
+func JobName(ctx context.Context) string {
+	name, _ := ctx.Value(jobNameKey{}).(string)
+	return name
+}
+
+type job struct {
+	Job
+	running atomic.Bool
+
+	mu     sync.Mutex
+	status JobStatus
+}
+
+// Scheduler runs each job in its own goroutine.
+// A job is never run again while its previous run is still in progress.
+type Scheduler struct {
+	jobs    []*job
+	started atomic.Bool
+	// inflight counts the runs of the jobs, including the ones which have outlived their timeout.
+	inflight sync.WaitGroup
+}
+
+func New() *Scheduler {
+	return &Scheduler{}
+}
+
+func (s *Scheduler) Add(j Job) {
+	s.jobs = append(s.jobs, &job{Job: j, status: JobStatus{Name: j.Name, Interval: j.Interval, Timeout: j.Timeout}})
+}
+
+// Started reports whether the scheduler is running its jobs.
+func (s *Scheduler) Started() bool {
+	return s.started.Load()
+}
+
+// Status returns the status of every job.
+func (s *Scheduler) Status() []JobStatus {
+	out := make([]JobStatus, len(s.jobs))
+	for i, j := range s.jobs {
+		j.mu.Lock()
+		out[i] = j.status
+		j.mu.Unlock()
+		out[i].Running = j.running.Load()
+	}
+	return out
+}
+
+// Run starts all jobs and blocks until ctx is done and every running job has returned.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.started.Store(true)
+	defer s.started.Store(false)
+
+	var wg sync.WaitGroup
+	for _, j := range s.jobs {
+		wg.Add(1)
+		go func(j *job) {
+			defer wg.Done()
+			s.loop(ctx, j)
+		}(j)
+	}
+	wg.Wait()
+	s.inflight.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, j *job) {
+	log.Info(ctx, fmt.Sprintf("[scheduler] start %s. interval [%s], timeout [%s]", j.Name, j.Interval, j.Timeout))
+	j.mu.Lock()
+	j.status.StartedAt = time.Now()
+	j.mu.Unlock()
+
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+	for {
+		s.run(ctx, j)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, j *job) {
+	if !j.running.CompareAndSwap(false, true) {
+		log.Warn(ctx, fmt.Sprintf("[scheduler] %s is still running. skip this time", j.Name))
+		return
+	}
+
+	runCtx, cancel := context.WithTimeout(context.WithValue(ctx, jobNameKey{}, j.Name), j.Timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	s.inflight.Add(1)
+	go func() {
+		defer s.inflight.Done()
+		defer j.running.Store(false)
+		start := time.Now()
+		err := j.Run(runCtx)
+		j.finish(start, err)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Error(ctx, fmt.Sprintf("[scheduler] %s failed. err : ", j.Name), err)
+		}
+	case <-runCtx.Done():
+		log.Error(ctx, fmt.Sprintf("[scheduler] %s did not finish in %s", j.Name, j.Timeout))
+	}
+}
+
+func (j *job) finish(start time.Time, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.LastRun = start
+	j.status.LastDuration = time.Since(start)
+	j.status.LastError = ""
+	if err != nil {
+		j.status.LastError = err.Error()
+	} else {
+		j.status.LastSuccess = time.Now()
+	}
+}
@@ -0,0 +1,70 @@
+package statusEntry
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StatusEntry is when an entity was first seen in its current status.
+type StatusEntry struct {
+	Kind      string `gorm:"primarykey"`
+	EntityId  string `gorm:"primarykey"`
+	Status    string
+	EnteredAt time.Time
+}
+
+// StatusEntryAccessor accesses status entries in DB.
+type StatusEntryAccessor struct {
+	db *gorm.DB
+}
+
+// New returns new accessor's ptr.
+func New(db *gorm.DB) *StatusEntryAccessor {
+	return &StatusEntryAccessor{
+		db: db,
+	}
+}
+
+// For Unittest
+func (x *StatusEntryAccessor) GetDb() *gorm.DB {
+	return x.db
+}
+
+// Migrate creates the table owned by tks-batch.
+func (x *StatusEntryAccessor) Migrate() error {
+	return x.db.AutoMigrate(&StatusEntry{})
+}
+
+// EnteredAt returns when the entity was first seen in the status.
+// An entity seen in another status than the recorded one enters the status now.
+func (x *StatusEntryAccessor) EnteredAt(kind string, entityId string, status string) (time.Time, error) {
+	res := x.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "kind"}, {Name: "entity_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"status":     gorm.Expr("excluded.status"),
+			"entered_at": gorm.Expr("excluded.entered_at"),
+		}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "status_entries.status <> excluded.status"},
+		}},
+	}).Create(&StatusEntry{
+		Kind:      kind,
+		EntityId:  entityId,
+		Status:    status,
+		EnteredAt: time.Now(),
+	})
+	if res.Error != nil {
+		return time.Time{}, res.Error
+	}
+
+	var entry StatusEntry
+	res = x.db.
+		Where("kind = ? AND entity_id = ?", kind, entityId).
+		First(&entry)
+	if res.Error != nil {
+		return time.Time{}, res.Error
+	}
+	return entry.EnteredAt, nil
+}
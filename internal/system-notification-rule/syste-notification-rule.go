@@ -65,7 +65,7 @@ type SystemNotificationRule struct {
 	Organization                 Organization               `gorm:"foreignKey:OrganizationId"`
 	SystemNotificationTemplate   SystemNotificationTemplate `gorm:"foreignKey:SystemNotificationTemplateId"`
 	SystemNotificationTemplateId string
-	SystemNotificationCondition  SystemNotificationCondition `gorm:"foreignKey:SystemNotificationRuleId"`
+	SystemNotificationConditions []SystemNotificationCondition `gorm:"foreignKey:SystemNotificationRuleId"`
 	MessageTitle                 string
 	MessageContent               string
 	MessageActionProposal        string
@@ -89,16 +89,26 @@ func (x *SystemNotificationAccessor) GetDb() *gorm.DB {
 	return x.db
 }
 
+func orderByConditionOrder(db *gorm.DB) *gorm.DB {
+	return db.Order(`"order"`)
+}
+
+// deletedNotSynced is the condition of the rules which are deleted after they have been written to the cluster.
+// Writing the rules of the organization sets updated_at of its deleted rules after deleted_at.
+const deletedNotSynced = "(system_notification_rules.deleted_at IS NOT NULL AND system_notification_rules.updated_at < system_notification_rules.deleted_at)"
+
+// GetIncompletedRules returns the pending rules and the deleted rules which are not removed from the cluster yet.
 func (x *SystemNotificationAccessor) GetIncompletedRules() ([]SystemNotificationRule, error) {
 	var rules []SystemNotificationRule
 
 	res := x.db.Model(&SystemNotificationRule{}).
 		Preload(clause.Associations).
+		Preload("SystemNotificationConditions", orderByConditionOrder).
 		Preload("SystemNotificationTemplate.MetricParameters").
 		Joins("join organizations on organizations.id = system_notification_rules.organization_id").
 		Joins("join clusters on clusters.id = organizations.primary_cluster_id AND clusters.status = ?", domain.ClusterStatus_RUNNING).
 		Joins("join app_groups on app_groups.cluster_id = clusters.id AND app_groups.status = ?", domain.AppGroupStatus_RUNNING).
-		Where("system_notification_rules.status = ?", domain.SystemNotificationRuleStatus_PENDING).
+		Where("(system_notification_rules.status = ? OR "+deletedNotSynced+")", domain.SystemNotificationRuleStatus_PENDING).
 		Unscoped().
 		Find(&rules)
 
@@ -109,31 +119,12 @@ func (x *SystemNotificationAccessor) GetIncompletedRules() ([]SystemNotification
 	return rules, nil
 }
 
-func (x *SystemNotificationAccessor) GetRecentlyUpdatedOrganizations(lastUpdateMin int) ([]string, error) {
-	var organizationIds []string
-
-	res := x.db.Model(&SystemNotificationRule{}).
-		Select("system_notification_rules.organization_id").
-		Joins("join organizations on organizations.id = system_notification_rules.organization_id").
-		Joins("join clusters on clusters.id = organizations.primary_cluster_id AND clusters.status = ?", domain.ClusterStatus_RUNNING).
-		Joins("join app_groups on app_groups.cluster_id = clusters.id AND app_groups.status = ?", domain.AppGroupStatus_RUNNING).
-		Where("system_notification_rules.status = ?", domain.SystemNotificationRuleStatus_APPLIED).
-		Where(fmt.Sprintf("system_notification_rules.updated_at between now()-interval '%d minutes' and now() OR system_notification_rules.deleted_at between now()-interval '%d minutes' and now()", lastUpdateMin, lastUpdateMin)).
-		Group("system_notification_rules.organization_id").
-		Unscoped().
-		Find(&organizationIds)
-
-	if res.Error != nil {
-		return nil, res.Error
-	}
-	return organizationIds, nil
-}
-
 func (x *SystemNotificationAccessor) GetRules(organizationId string) ([]SystemNotificationRule, error) {
 	var rules []SystemNotificationRule
 
 	res := x.db.Model(&SystemNotificationRule{}).
 		Preload(clause.Associations).
+		Preload("SystemNotificationConditions", orderByConditionOrder).
 		Preload("SystemNotificationTemplate.MetricParameters").
 		Joins("join organizations on organizations.id = system_notification_rules.organization_id").
 		Where("organization_id = ?", organizationId).
@@ -146,15 +137,45 @@ func (x *SystemNotificationAccessor) GetRules(organizationId string) ([]SystemNo
 	return rules, nil
 }
 
-func (x SystemNotificationAccessor) UpdateSystemNotificationRuleStatus(organizationId string, status domain.SystemNotificationRuleStatus) error {
-	log.Info(context.TODO(), fmt.Sprintf("organizationId[%v], status[%d]", organizationId, status))
+// UpdateRulesStatus updates the status of the rules.
+func (x SystemNotificationAccessor) UpdateRulesStatus(ruleIds []uuid.UUID, status domain.SystemNotificationRuleStatus) error {
+	if len(ruleIds) == 0 {
+		return nil
+	}
+	log.Info(context.TODO(), fmt.Sprintf("ruleIds[%v], status[%d]", ruleIds, status))
+	res := x.db.Model(SystemNotificationRule{}).
+		Where("id IN ?", ruleIds).
+		Updates(map[string]interface{}{"Status": status})
+
+	if res.Error != nil {
+		return fmt.Errorf("nothing updated in SystemNotificationRuleStatus with ids %v. err : %s", ruleIds, res.Error)
+	}
+	return nil
+}
+
+// MarkDeletedRulesSynced marks the deleted rules of the organization as removed from the cluster.
+// updated_at is set to deleted_at at least, so that the rules are not incompleted any more
+// even if deleted_at is set by a host whose clock runs ahead of the database.
+func (x SystemNotificationAccessor) MarkDeletedRulesSynced(organizationId string) error {
 	res := x.db.Model(SystemNotificationRule{}).
-		Where("organization_id = ?", organizationId).
+		Where("organization_id = ? AND "+deletedNotSynced, organizationId).
 		Unscoped().
+		UpdateColumns(map[string]interface{}{"status": domain.SystemNotificationRuleStatus_APPLIED, "updated_at": gorm.Expr("GREATEST(now(), system_notification_rules.deleted_at)")})
+
+	if res.Error != nil {
+		return res.Error
+	}
+	return nil
+}
+
+func (x SystemNotificationAccessor) UpdateRuleStatus(ruleId uuid.UUID, status domain.SystemNotificationRuleStatus) error {
+	log.Info(context.TODO(), fmt.Sprintf("ruleId[%v], status[%d]", ruleId, status))
+	res := x.db.Model(SystemNotificationRule{}).
+		Where("id = ?", ruleId).
 		Updates(map[string]interface{}{"Status": status})
 
 	if res.Error != nil || res.RowsAffected == 0 {
-		return fmt.Errorf("nothing updated in SystemNotificationRuleStatus with organizationId %s", organizationId)
+		return fmt.Errorf("nothing updated in SystemNotificationRuleStatus with id %s", ruleId)
 	}
 	return nil
 }
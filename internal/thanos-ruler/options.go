@@ -0,0 +1,73 @@
+package thanosRuler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	DEFAULT_TIMEOUT      = 30 * time.Second
+	DEFAULT_MAX_ATTEMPTS = 3
+	DEFAULT_BACKOFF      = time.Second
+)
+
+// Options configures how a client reaches thanos-ruler.
+type Options struct {
+	// HttpClient is used as it is when set, such as the one authenticated to the api-server.
+	// The TLS options are ignored then.
+	HttpClient *http.Client
+	Timeout    time.Duration
+
+	// CA is a PEM bundle to verify thanos-ruler with, in addition to the system roots.
+	CA []byte
+	// Cert and Key are a PEM client certificate for mTLS.
+	Cert               []byte
+	Key                []byte
+	InsecureSkipVerify bool
+
+	// BearerToken takes precedence over Username and Password.
+	BearerToken string
+	Username    string
+	Password    string
+
+	// MaxAttempts is how many times a request is sent on transport errors and 5xx responses.
+	MaxAttempts int
+	// Backoff is the wait before the second attempt. It doubles on every attempt.
+	Backoff time.Duration
+}
+
+func newHttpClient(opts Options) (*http.Client, error) {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_TIMEOUT
+	}
+	if len(opts.CA) == 0 && len(opts.Cert) == 0 && !opts.InsecureSkipVerify {
+		return &http.Client{Timeout: timeout}, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+	if len(opts.CA) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(opts.CA) {
+			return nil, fmt.Errorf("no certificate in the CA bundle")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(opts.Cert) > 0 || len(opts.Key) > 0 {
+		cert, err := tls.X509KeyPair(opts.Cert, opts.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate. err : %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
@@ -0,0 +1,176 @@
+package thanosRuler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// the part of the response body kept in an error
+const MAX_ERROR_BODY = 512
+
+// Rule health reported by thanos-ruler
+const (
+	HealthOk      = "ok"
+	HealthErr     = "err"
+	HealthUnknown = "unknown"
+)
+
+// Rule is a rule loaded in thanos-ruler.
+type Rule struct {
+	Name        string            `json:"name"`
+	Query       string            `json:"query"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	Health      string            `json:"health"`
+	LastError   string            `json:"lastError"`
+	Type        string            `json:"type"`
+}
+
+// Group is a rule group loaded in thanos-ruler.
+type Group struct {
+	Name  string `json:"name"`
+	File  string `json:"file"`
+	Rules []Rule `json:"rules"`
+}
+
+type rulesResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		Groups []Group `json:"groups"`
+	} `json:"data"`
+	Error string `json:"error"`
+}
+
+// Client calls the http api of thanos-ruler.
+type Client struct {
+	url         string
+	http        *http.Client
+	bearerToken string
+	username    string
+	password    string
+	maxAttempts int
+	backoff     time.Duration
+}
+
+func New(url string) *Client {
+	client, _ := NewWithOptions(url, Options{})
+	return client
+}
+
+// NewWithOptions makes a client with the TLS, credential and retry options.
+// It fails if the certificates in the options are invalid.
+func NewWithOptions(url string, opts Options) (*Client, error) {
+	httpClient := opts.HttpClient
+	if httpClient == nil {
+		var err error
+		if httpClient, err = newHttpClient(opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DEFAULT_MAX_ATTEMPTS
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = DEFAULT_BACKOFF
+	}
+	return &Client{
+		url:         url,
+		http:        httpClient,
+		bearerToken: opts.BearerToken,
+		username:    opts.Username,
+		password:    opts.Password,
+		maxAttempts: opts.MaxAttempts,
+		backoff:     opts.Backoff,
+	}, nil
+}
+
+// Reload makes thanos-ruler read its rule files again.
+// thanos-ruler responds with an error status when it fails to load them.
+func (c *Client) Reload(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/-/reload", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+
+	_, err = c.do(req)
+	return err
+}
+
+// Rules returns the rule groups loaded in thanos-ruler.
+func (c *Client) Rules(ctx context.Context) ([]Group, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"/api/v1/rules?type=alert", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var out rulesResponse
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, fmt.Errorf("invalid rules response. err : %s", err)
+	}
+	if out.Status != "success" {
+		return nil, fmt.Errorf("failed to get rules. status : %s, error : %s", out.Status, out.Error)
+	}
+	return out.Data.Groups, nil
+}
+
+// do sends the request again with backoff on transport errors and 5xx responses.
+func (c *Client) do(req *http.Request) ([]byte, error) {
+	c.authorize(req)
+
+	var err error
+	for attempt := 1; attempt <= c.maxAttempts; attempt++ {
+		var body []byte
+		var retryable bool
+		if body, retryable, err = c.send(req); err == nil || !retryable {
+			return body, err
+		}
+		if attempt == c.maxAttempts {
+			break
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(c.backoff << (attempt - 1)):
+		}
+	}
+	return nil, fmt.Errorf("failed after %d attempts. %s", c.maxAttempts, err)
+}
+
+func (c *Client) authorize(req *http.Request) {
+	if c.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.bearerToken)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
+
+func (c *Client) send(req *http.Request) (body []byte, retryable bool, err error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, req.Context().Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if len(body) > MAX_ERROR_BODY {
+			body = body[:MAX_ERROR_BODY]
+		}
+		return nil, resp.StatusCode >= 500, fmt.Errorf("Invalid http status. return code: %d, body: %s", resp.StatusCode, string(body))
+	}
+	return body, false, nil
+}
@@ -0,0 +1,79 @@
+package workflowRetry
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WorkflowRetry counts the retries of a failed workflow of an entity.
+type WorkflowRetry struct {
+	Kind          string `gorm:"primarykey"`
+	EntityId      string `gorm:"primarykey"`
+	WorkflowId    string `gorm:"primarykey"`
+	Attempts      int
+	LastAttemptAt time.Time
+}
+
+// WorkflowRetryAccessor accesses workflow retries in DB.
+type WorkflowRetryAccessor struct {
+	db *gorm.DB
+}
+
+// New returns new accessor's ptr.
+func New(db *gorm.DB) *WorkflowRetryAccessor {
+	return &WorkflowRetryAccessor{
+		db: db,
+	}
+}
+
+// For Unittest
+func (x *WorkflowRetryAccessor) GetDb() *gorm.DB {
+	return x.db
+}
+
+// Migrate creates the table owned by tks-batch.
+func (x *WorkflowRetryAccessor) Migrate() error {
+	return x.db.AutoMigrate(&WorkflowRetry{})
+}
+
+// Attempts returns how many times the workflow has been retried and when it was retried last.
+func (x *WorkflowRetryAccessor) Attempts(kind string, entityId string, workflowId string) (int, time.Time, error) {
+	var retry WorkflowRetry
+
+	res := x.db.
+		Where("kind = ? AND entity_id = ? AND workflow_id = ?", kind, entityId, workflowId).
+		First(&retry)
+
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return 0, time.Time{}, nil
+	}
+	if res.Error != nil {
+		return 0, time.Time{}, res.Error
+	}
+	return retry.Attempts, retry.LastAttemptAt, nil
+}
+
+// AddAttempt increases the retry count of the workflow.
+func (x *WorkflowRetryAccessor) AddAttempt(kind string, entityId string, workflowId string) error {
+	res := x.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "kind"}, {Name: "entity_id"}, {Name: "workflow_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"attempts":        gorm.Expr("workflow_retries.attempts + 1"),
+			"last_attempt_at": time.Now(),
+		}),
+	}).Create(&WorkflowRetry{
+		Kind:          kind,
+		EntityId:      entityId,
+		WorkflowId:    workflowId,
+		Attempts:      1,
+		LastAttemptAt: time.Now(),
+	})
+
+	if res.Error != nil {
+		return res.Error
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// RetryClient retries failed workflows through argo-server.
+type RetryClient struct {
+	url  string
+	http *http.Client
+}
+
+func NewRetryClient(argoUrl string) *RetryClient {
+	return &RetryClient{
+		url:  argoUrl,
+		http: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// RetryWorkflow reruns the failed steps of the workflow.
+func (c *RetryClient) RetryWorkflow(ctx context.Context, namespace string, workflowName string) error {
+	body, err := json.Marshal(map[string]string{
+		"name":      workflowName,
+		"namespace": namespace,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, fmt.Sprintf("%s/api/v1/workflows/%s/%s/retry", c.url, namespace, workflowName), bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Invalid http status. return code: %d", res.StatusCode)
+	}
+	return nil
+}
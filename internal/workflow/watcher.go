@@ -0,0 +1,169 @@
+package workflow
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	argo "github.com/openinfradev/tks-api/pkg/argo-client"
+	"github.com/openinfradev/tks-api/pkg/log"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+type watchEvent struct {
+	Result struct {
+		Type   string          `json:"type"`
+		Object json.RawMessage `json:"object"`
+	} `json:"result"`
+}
+
+type workflowNodes struct {
+	Status struct {
+		Nodes map[string]struct {
+			DisplayName string `json:"displayName"`
+			Phase       string `json:"phase"`
+		} `json:"nodes"`
+	} `json:"status"`
+}
+
+// Watcher subscribes to workflow events of argo-server and keeps the latest state of each workflow.
+// Workflows not known to the watcher are read from argo directly.
+type Watcher struct {
+	client  argo.ArgoClient
+	url     string
+	store   *store
+	http    *http.Client
+	changed atomic.Uint64
+}
+
+func NewWatcher(client argo.ArgoClient, argoUrl string, namespace string) *Watcher {
+	return &Watcher{
+		client: client,
+		url:    argoUrl,
+		store:  newStore(namespace),
+		http:   &http.Client{},
+	}
+}
+
+// Generation is increased whenever a workflow has changed.
+// Callers compare it with the value they have seen before to find out whether anything changed.
+func (w *Watcher) Generation() uint64 {
+	return w.changed.Load()
+}
+
+func (w *Watcher) GetWorkflow(ctx context.Context, namespace string, workflowName string) (*argo.Workflow, error) {
+	if e, ok := w.store.get(namespace, workflowName); ok {
+		return &e.workflow, nil
+	}
+	return w.client.GetWorkflow(ctx, namespace, workflowName)
+}
+
+func (w *Watcher) IsPausedWorkflow(ctx context.Context, namespace string, workflowName string) (bool, error) {
+	if e, ok := w.store.get(namespace, workflowName); ok {
+		return e.paused, nil
+	}
+	return w.client.IsPausedWorkflow(ctx, namespace, workflowName)
+}
+
+// Run watches workflow events until ctx is done, reconnecting with backoff.
+func (w *Watcher) Run(ctx context.Context) {
+	backoff := minBackoff
+	for {
+		err := w.watch(ctx)
+		w.store.invalidate()
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warn(ctx, fmt.Sprintf("argo workflow watch closed. reconnect after %s. err : ", backoff), err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if err == nil {
+			backoff = minBackoff
+		} else if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (w *Watcher) watch(ctx context.Context) error {
+	// resync, since events may have been missed while disconnected.
+	res, err := w.client.GetWorkflows(ctx, w.store.namespace)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/v1/workflow-events/%s", w.url, w.store.namespace), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := w.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Invalid http status. return code: %d", resp.StatusCode)
+	}
+
+	w.store.replace(res.Items)
+	w.changed.Add(1)
+	log.Info(ctx, fmt.Sprintf("watching argo workflows in namespace %s. workflows : %d", w.store.namespace, len(res.Items)))
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event watchEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			log.Error(ctx, "failed to parse argo workflow event. err : ", err)
+			continue
+		}
+		w.handle(ctx, event)
+	}
+	return scanner.Err()
+}
+
+func (w *Watcher) handle(ctx context.Context, event watchEvent) {
+	var wf argo.Workflow
+	if err := json.Unmarshal(event.Result.Object, &wf); err != nil {
+		log.Error(ctx, "failed to parse argo workflow. err : ", err)
+		return
+	}
+
+	if event.Result.Type == "DELETED" {
+		w.store.delete(wf.Metadata.Name)
+		w.changed.Add(1)
+		return
+	}
+
+	var nodes workflowNodes
+	if err := json.Unmarshal(event.Result.Object, &nodes); err != nil {
+		log.Error(ctx, "failed to parse argo workflow nodes. err : ", err)
+	}
+	paused := false
+	for _, node := range nodes.Status.Nodes {
+		if node.DisplayName == "suspend" && node.Phase == "Running" {
+			paused = true
+			break
+		}
+	}
+
+	if w.store.set(entry{workflow: wf, paused: paused}) {
+		log.Debug(ctx, fmt.Sprintf("workflow [%s] changed. phase [%s]", wf.Metadata.Name, wf.Status.Phase))
+		w.changed.Add(1)
+	}
+}
@@ -0,0 +1,157 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	argo "github.com/openinfradev/tks-api/pkg/argo-client"
+	"github.com/openinfradev/tks-api/pkg/log"
+)
+
+// Source modes
+const (
+	ModePoll  = "poll"
+	ModeList  = "list"
+	ModeWatch = "watch"
+)
+
+// Source provides argo workflows to the reconciler.
+type Source interface {
+	GetWorkflow(ctx context.Context, namespace string, workflowName string) (*argo.Workflow, error)
+	IsPausedWorkflow(ctx context.Context, namespace string, workflowName string) (bool, error)
+}
+
+type entry struct {
+	workflow argo.Workflow
+	paused   bool
+}
+
+// store keeps the last known state of workflows of a namespace.
+type store struct {
+	mu        sync.RWMutex
+	namespace string
+	items     map[string]entry
+	synced    bool
+}
+
+func newStore(namespace string) *store {
+	return &store{
+		namespace: namespace,
+		items:     make(map[string]entry),
+	}
+}
+
+func (s *store) get(namespace string, name string) (entry, bool) {
+	if namespace != s.namespace {
+		return entry{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.synced {
+		return entry{}, false
+	}
+	e, ok := s.items[name]
+	return e, ok
+}
+
+// set stores the workflow and reports whether its phase, progress or message has changed.
+func (s *store) set(e entry) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.items[e.workflow.Metadata.Name]
+	s.items[e.workflow.Metadata.Name] = e
+	return !ok || old.paused != e.paused || old.workflow.Status != e.workflow.Status
+}
+
+func (s *store) delete(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, name)
+}
+
+func (s *store) replace(items []argo.Workflow) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.items
+	s.items = make(map[string]entry, len(items))
+	for _, wf := range items {
+		// listed workflows do not include their nodes, so keep the last known paused state.
+		e, ok := old[wf.Metadata.Name]
+		s.items[wf.Metadata.Name] = entry{workflow: wf, paused: ok && e.paused}
+	}
+	s.synced = true
+}
+
+func (s *store) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.synced = false
+}
+
+// Lister lists all workflows of the namespace with a single call
+// and serves the result until it gets older than ttl.
+type Lister struct {
+	client    argo.ArgoClient
+	store     *store
+	ttl       time.Duration
+	mu        sync.Mutex
+	fetchedAt time.Time
+}
+
+func NewLister(client argo.ArgoClient, namespace string, ttl time.Duration) *Lister {
+	return &Lister{
+		client: client,
+		store:  newStore(namespace),
+		ttl:    ttl,
+	}
+}
+
+func (l *Lister) refresh(ctx context.Context) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Since(l.fetchedAt) < l.ttl {
+		return
+	}
+	res, err := l.client.GetWorkflows(ctx, l.store.namespace)
+	if err != nil {
+		log.Error(ctx, "failed to list argo workflows. err : ", err)
+		l.store.invalidate()
+		return
+	}
+	l.store.replace(res.Items)
+	l.fetchedAt = time.Now()
+}
+
+func (l *Lister) GetWorkflow(ctx context.Context, namespace string, workflowName string) (*argo.Workflow, error) {
+	l.refresh(ctx)
+	if e, ok := l.store.get(namespace, workflowName); ok {
+		return &e.workflow, nil
+	}
+	return l.client.GetWorkflow(ctx, namespace, workflowName)
+}
+
+// IsPausedWorkflow is delegated to argo because listed workflows do not include their nodes.
+func (l *Lister) IsPausedWorkflow(ctx context.Context, namespace string, workflowName string) (bool, error) {
+	return l.client.IsPausedWorkflow(ctx, namespace, workflowName)
+}
+
+// NewSource returns the workflow source for the mode.
+func NewSource(mode string, client argo.ArgoClient, argoUrl string, namespace string, interval time.Duration) (Source, error) {
+	switch mode {
+	case ModePoll:
+		return client, nil
+	case ModeList:
+		return NewLister(client, namespace, interval), nil
+	case ModeWatch:
+		return NewWatcher(client, argoUrl, namespace), nil
+	}
+	return nil, fmt.Errorf("invalid workflow source mode %s", mode)
+}
+
+// IsNotFound reports whether err is returned by argo for a workflow which does not exist.
+func IsNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "return code: 404")
+}